@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/digtux/laminar/pkg/imageref"
 	"github.com/digtux/laminar/pkg/logger"
 	"github.com/jinzhu/copier"
 )
@@ -20,8 +21,7 @@ func DoChange(change ChangeRequest) (result bool) {
 	}
 
 	// assemble the full strings of the images
-	oldString := fmt.Sprintf("%s:%s", change.Image, change.Old)
-	newString := fmt.Sprintf("%s:%s", change.Image, change.New)
+	oldString, newString := change.imageStrings()
 
 	logger.Debugw("Doing change",
 		"old", oldString,
@@ -29,7 +29,21 @@ func DoChange(change ChangeRequest) (result bool) {
 	)
 
 	// apply changes to stringContents
-	stringContents = strings.ReplaceAll(string(r), oldString, newString)
+	if len(change.Lines) == 0 {
+		stringContents = strings.ReplaceAll(string(r), oldString, newString)
+	} else {
+		// only touch the lines the image was found on (others may be ignored or use another policy)
+		lines := strings.Split(string(r), "\n")
+		done := map[int]bool{}
+		for _, n := range change.Lines {
+			// each line is rewritten once, a second pass would extend a new tag starting with the old one
+			if n > 0 && n <= len(lines) && !done[n] {
+				lines[n-1] = strings.ReplaceAll(lines[n-1], oldString, newString)
+				done[n] = true
+			}
+		}
+		stringContents = strings.Join(lines, "\n")
+	}
 
 	// see if it changed
 	if originalContents != stringContents {
@@ -45,3 +59,11 @@ func DoChange(change ChangeRequest) (result bool) {
 	logger.Infow("no changes detected")
 	return false
 }
+
+// imageStrings returns the image strings as they appear in git before and after the change
+func (change ChangeRequest) imageStrings() (oldString, newString string) {
+	if change.DigestOnly {
+		return imageref.Format(change.Image, "", change.OldDigest), imageref.Format(change.Image, "", change.NewDigest)
+	}
+	return imageref.Format(change.Image, change.Old, change.OldDigest), imageref.Format(change.Image, change.New, change.NewDigest)
+}
@@ -23,7 +23,7 @@ func TestNicerMessage(t *testing.T) {
 	for _, test := range regexTests {
 		s := nicerMessage(test.input)
 		if s != test.output {
-			t.Errorf("TestRegex(%s), got: '%s' but expected: '%s'", test.input, s, test.output)
+			t.Errorf("TestRegex(%v), got: '%s' but expected: '%s'", test.input, s, test.output)
 		}
 	}
 }
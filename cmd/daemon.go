@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/digtux/laminar/pkg/web"
@@ -14,6 +15,8 @@ import (
 	"github.com/digtux/laminar/pkg/gitoperations"
 	"github.com/digtux/laminar/pkg/logger"
 	"github.com/digtux/laminar/pkg/operations"
+	"github.com/digtux/laminar/pkg/pin"
+	"github.com/digtux/laminar/pkg/policy"
 	"github.com/digtux/laminar/pkg/registry"
 	"github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
@@ -51,6 +54,8 @@ type Daemon struct {
 	gitConfig        cfg.Global
 	gitOpsClient     *gitoperations.Client
 	opsClient        *operations.Client
+	pinStore         *pin.Store
+	forbiddenTags    *policy.ForbiddenTags
 }
 
 func New() (d *Daemon, err error) {
@@ -58,16 +63,23 @@ func New() (d *Daemon, err error) {
 	if err != nil {
 		return nil, err
 	}
+	forbiddenTags, err := newForbiddenTags(appConfig)
+	if err != nil {
+		return nil, err
+	}
 	cacheDB := cache.Open(configCache)
+	pinStore := pin.New(cacheDB)
 	d = &Daemon{
 		cacheDB:          cacheDB,
 		dockerRegistries: mapDockerRegistries(appConfig.DockerRegistries),
+		forbiddenTags:    forbiddenTags,
 		gitConfig:        appConfig.Global,
 		gitOpsClient:     gitoperations.New(appConfig.Global),
 		gitState:         nil,
 		opsClient:        operations.New(),
-		registryClient:   registry.New(cacheDB),
-		webClient:        web.New(appConfig),
+		pinStore:         pinStore,
+		registryClient:   registry.New(cacheDB, appConfig.DockerRegistries...),
+		webClient:        web.New(appConfig, pinStore),
 	}
 	d.initialiseGitState(appConfig.GitRepos)
 	return
@@ -81,11 +93,22 @@ func mapDockerRegistries(registries []cfg.DockerRegistry) map[string]cfg.DockerR
 	return result
 }
 
+// newForbiddenTags parses the global and per registry forbiddenTags
+func newForbiddenTags(appConfig cfg.Config) (*policy.ForbiddenTags, error) {
+	return policy.NewForbiddenTags(appConfig.Global.ForbiddenTags, appConfig.DockerRegistries)
+}
+
 func loadConfig() (appConfig cfg.Config, err error) {
 	var rawFile []byte
 	if rawFile, err = cfg.LoadFile(configFile); err == nil {
 		if appConfig, err = cfg.ParseConfig(rawFile); err != nil {
 			err = errors.Wrap(err, "error parsing config file")
+		} else if err = validateUpdatePolicies(appConfig.GitRepos); err != nil {
+			err = errors.Wrap(err, "invalid update policy")
+		} else if _, err = newForbiddenTags(appConfig); err != nil {
+			err = errors.Wrap(err, "invalid forbiddenTags")
+		} else if err = validateDockerRegistries(appConfig.DockerRegistries); err != nil {
+			err = errors.Wrap(err, "invalid docker registry")
 		}
 	} else {
 		err = errors.Wrap(err, "error reading config")
@@ -99,6 +122,39 @@ func loadConfig() (appConfig cfg.Config, err error) {
 	return
 }
 
+// validateUpdatePolicies ensures every pattern (and blacklist) can be parsed
+// so that misconfigurations are caught at startup rather than halfway through a run
+func validateUpdatePolicies(repos []cfg.GitRepo) error {
+	for _, repo := range repos {
+		for _, update := range repo.Updates {
+			if err := policy.Validate(update); err != nil {
+				return errors.Wrapf(err, "git repo %q", repo.Name)
+			}
+			if err := validatePromoteFrom(repo, update); err != nil {
+				return errors.Wrapf(err, "git repo %q", repo.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDockerRegistries ensures every registry has a worker for its type, sensible limits
+// and that its auth block sets one kind of credentials
+func validateDockerRegistries(registries []cfg.DockerRegistry) error {
+	for _, reg := range registries {
+		if err := registry.ValidateType(reg); err != nil {
+			return errors.Wrapf(err, "registry %q", reg.Reg)
+		}
+		if err := registry.ValidateLimits(reg); err != nil {
+			return errors.Wrapf(err, "registry %q", reg.Reg)
+		}
+		if err := registry.ValidateRegistryAuth(reg); err != nil {
+			return errors.Wrapf(err, "registry %q", reg.Reg)
+		}
+	}
+	return nil
+}
+
 //goland:noinspection GoMixedReceiverTypes
 func (d *Daemon) initialiseGitState(repos []cfg.GitRepo) {
 	d.gitState = make([]GitState, len(repos))
@@ -209,13 +265,19 @@ func (d *Daemon) updateFiles(gitRepo cfg.GitRepo) {
 			fileList = append(fileList, filesFound...)
 		}
 
+		// vetoes which depend on the state of the git repo
+		var vetoes []policy.Veto
+		if veto := d.promoteFromVeto(gitRepo, updatePolicy); veto != nil {
+			vetoes = append(vetoes, veto)
+		}
+
 		for _, filePath := range fileList {
 			logger.Debugw("applying update policy",
 				"file", filePath,
 				"pattern", updatePolicy.PatternString,
 				"blacklist", updatePolicy.BlackList,
 			)
-			newChanges := d.doUpdate(filePath, updatePolicy, registryStrings)
+			newChanges := d.doUpdate(filePath, updatePolicy, registryStrings, vetoes...)
 			if len(newChanges) > 0 {
 				logger.Infow("updates desired",
 					"file", filePath,
@@ -249,9 +311,16 @@ func (d *Daemon) commitAndPush(changes []ChangeRequest, cfgGit cfg.GitRepo) {
 
 //goland:noinspection GoMixedReceiverTypes
 func (d *Daemon) scanDockerRegistries() {
+	// registries are scanned at the same time, each with its own pool of workers (see maxConcurrency)
+	var wg sync.WaitGroup
 	for _, dockerReg := range d.dockerRegistries {
-		d.scanDockerRegistry(dockerReg)
+		wg.Add(1)
+		go func(dockerReg cfg.DockerRegistry) {
+			defer wg.Done()
+			d.scanDockerRegistry(dockerReg)
+		}(dockerReg)
 	}
+	wg.Wait()
 }
 
 //goland:noinspection GoMixedReceiverTypes
@@ -308,6 +377,20 @@ func (d *Daemon) updateGitRepoState(state GitState) {
 		state.repoCfg.Updates = make([]cfg.Updates, 0)
 		// now assemble that list for this run
 		for _, update := range remoteUpdates.Updates {
+			if err := policy.Validate(update); err != nil {
+				logger.Warnw("ignoring invalid update policy from .laminar.yaml",
+					"repo", state.repoCfg.Name,
+					"error", err,
+				)
+				continue
+			}
+			if err := validatePromoteFrom(cfg.GitRepo{Updates: remoteUpdates.Updates}, update); err != nil {
+				logger.Warnw("ignoring invalid update policy from .laminar.yaml",
+					"repo", state.repoCfg.Name,
+					"error", err,
+				)
+				continue
+			}
 			logger.Infow("using 'remote config' from gitoperations repo .laminar.yaml",
 				"update", update,
 			)
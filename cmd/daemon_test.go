@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/operations"
+	"github.com/digtux/laminar/pkg/pin"
+	"github.com/digtux/laminar/pkg/registry"
+	"github.com/tidwall/buntdb"
+)
+
+// fakeWorker serves tags from memory (registry type "fake") and records the images it was asked for
+type fakeWorker struct {
+	tags   map[string][]registry.TagInfo
+	mu     sync.Mutex
+	listed []string
+}
+
+var (
+	fakeWorkersMu sync.Mutex
+	fakeWorkers   = map[string]*fakeWorker{} // by registry "reg", see newFakeWorker
+)
+
+func init() {
+	registry.RegisterWorker("fake", func(reg cfg.DockerRegistry, _ *buntdb.DB) (registry.RegistryWorker, error) {
+		fakeWorkersMu.Lock()
+		defer fakeWorkersMu.Unlock()
+		w, ok := fakeWorkers[reg.Reg]
+		if !ok {
+			return nil, fmt.Errorf("no fake worker for %q", reg.Reg)
+		}
+		return w, nil
+	})
+}
+
+// newFakeWorker returns the worker of a "fake" registry for the duration of a test
+func newFakeWorker(t *testing.T, reg string, tags map[string][]registry.TagInfo) *fakeWorker {
+	t.Helper()
+	w := &fakeWorker{tags: tags}
+	fakeWorkersMu.Lock()
+	fakeWorkers[reg] = w
+	fakeWorkersMu.Unlock()
+	t.Cleanup(func() {
+		fakeWorkersMu.Lock()
+		delete(fakeWorkers, reg)
+		fakeWorkersMu.Unlock()
+	})
+	return w
+}
+
+func (w *fakeWorker) ListTags(_ context.Context, image string) ([]registry.TagInfo, error) {
+	// images are listed concurrently
+	w.mu.Lock()
+	w.listed = append(w.listed, image)
+	w.mu.Unlock()
+	tags, ok := w.tags[image]
+	if !ok {
+		return nil, fmt.Errorf("image %q not found", image)
+	}
+	return tags, nil
+}
+
+// listedImages returns the images the worker was asked for (sorted)
+func (w *fakeWorker) listedImages() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	listed := append([]string(nil), w.listed...)
+	sort.Strings(listed)
+	return listed
+}
+
+func TestScanDockerRegistryWithFakeWorker(t *testing.T) {
+	now := time.Now()
+	fake := newFakeWorker(t, "localhost:5000/team", map[string][]registry.TagInfo{
+		"localhost:5000/team/api": {
+			{Image: "localhost:5000/team/api", Hash: fmt.Sprintf("%064d", 1), Tag: "develop-1", Created: now.Add(-time.Hour)},
+			{Image: "localhost:5000/team/api", Hash: fmt.Sprintf("%064d", 2), Tag: "develop-2", Created: now},
+		},
+	})
+
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	err := os.WriteFile(file, []byte(`api: "localhost:5000/team/api:develop-1"
+web: localhost:5000/team/web:develop-1
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := cache.Open(":memory:")
+	d := &Daemon{
+		registryClient: registry.New(db),
+		opsClient:      operations.New(),
+		pinStore:       pin.New(db),
+		fileList:       []string{file},
+	}
+	d.scanDockerRegistry(cfg.DockerRegistry{Reg: "localhost:5000/team", Type: "fake"})
+
+	// a failing image doesn't stop the others being scanned
+	if expected := []string{"localhost:5000/team/api", "localhost:5000/team/web"}; !reflect.DeepEqual(fake.listedImages(), expected) {
+		t.Errorf("scanDockerRegistry listed, got: %v but expected: %v", fake.listedImages(), expected)
+	}
+
+	d.doUpdate(file, cfg.Updates{PatternString: "glob:develop-*"}, []string{"localhost:5000/team"})
+	_, contents := ReadFile(file)
+	expected := `api: "localhost:5000/team/api:develop-2"
+web: localhost:5000/team/web:develop-1
+`
+	if contents != expected {
+		t.Errorf("unexpected file contents after doUpdate, got:\n%s\nexpected:\n%s", contents, expected)
+	}
+}
@@ -2,7 +2,8 @@ package cmd
 
 import (
 	"github.com/digtux/laminar/pkg/common"
-	"strings"
+	"github.com/digtux/laminar/pkg/imageref"
+	"github.com/digtux/laminar/pkg/logger"
 )
 
 // FindDockerImages returns sorted and unique list of all docker images
@@ -15,10 +16,16 @@ func (d *Daemon) FindDockerImages(fileList []string, name string) (result []stri
 		imageHit := d.opsClient.Search(file, name)
 		for _, img := range imageHit {
 			// we don't want trailing @sha256 fields or :tag values, just the image name
-			// run a split ":" and only take the first field (we don't care about tags here)
-			i := strings.Split(img, "@")[0]
-			j := strings.Split(i, ":")[0]
-			result = append(result, j)
+			ref, err := imageref.Parse(imageref.Trim(img))
+			if err != nil {
+				logger.Debugw("ignoring invalid image reference",
+					"file", file,
+					"image", img,
+					"error", err,
+				)
+				continue
+			}
+			result = append(result, ref.Name())
 		}
 	}
 
@@ -5,15 +5,14 @@ import (
 	"bytes"
 	"log"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/digtux/laminar/pkg/cfg"
-	"github.com/digtux/laminar/pkg/common"
+	"github.com/digtux/laminar/pkg/imageref"
 	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/policy"
 	"github.com/digtux/laminar/pkg/registry"
-	"github.com/gobwas/glob"
 )
 
 // ChangeRequest is an object recording what changed and when
@@ -25,98 +24,97 @@ type ChangeRequest struct {
 	PatternType  string    `json:"patternType"`
 	Image        string    `json:"image"`
 	File         string    `json:"file"`
+	Lines        []int     `json:"lines,omitempty"` // only replace on these lines (all lines if empty)
+	OldDigest    string    `json:"oldDigest,omitempty"`
+	NewDigest    string    `json:"newDigest,omitempty"`
+	DigestOnly   bool      `json:"digestOnly,omitempty"` // git references "<image>@<digest>" without the tag
 }
 
-func (d *Daemon) doUpdate(filePath string, updates cfg.Updates, registryStrings []string) (changesDone []ChangeRequest) {
-	// split the "PatternString" (eg:  `glob:develop-*`) and determine the style
-	// TODO: do this check when loading config file
-	if len(strings.Split(updates.PatternString, ":")) != 2 {
-		logger.Fatalw("pattern string misconfigured.. ",
-			"got", updates.PatternString,
-			"expected", "'glob:foo-*'   or  'semver:~1.1'   (EG)",
+// imageOccurrence is a single image string found in a file
+type imageOccurrence struct {
+	candidate string // EG: "reg/acme/app:develop-abc"
+	line      int
+	marker    *lineMarker
+	markerErr error
+}
+
+// occurrenceGroup is all the lines sharing a candidate string and the effective update policy for them
+type occurrenceGroup struct {
+	candidate string
+	update    cfg.Updates
+	lines     []int
+}
+
+func (d *Daemon) doUpdate(
+	filePath string,
+	updates cfg.Updates,
+	registryStrings []string,
+	vetoes ...policy.Veto,
+) (changesDone []ChangeRequest) {
+	// patterns are validated when the config is loaded, this should only fail for a bad remote config
+	blackList, err := policy.NewBlackList(updates.BlackList)
+	if err != nil {
+		logger.Errorw("skipping update policy",
+			"file", filePath,
+			"error", err,
 		)
+		return nil
+	}
+	// cheap vetoes first, anything contacting a registry last
+	vetoes = append([]policy.Veto{
+		d.forbiddenTags.Veto,
+		blackList.Veto,
+		policy.MinAge(updates.MinAge),
+	}, vetoes...)
+	if veto := d.platformsVeto(updates.Platforms); veto != nil {
+		vetoes = append(vetoes, veto)
+	}
+	if veto := d.labelsVeto(updates.Labels); veto != nil {
+		vetoes = append(vetoes, veto)
+	}
+	if veto := d.signatureVeto(updates.SignatureKeys); veto != nil {
+		vetoes = append(vetoes, veto)
 	}
 
 	// slice of potential image strings to operate on
-	var potentialUpdatesAll []string
+	var occurrences []imageOccurrence
 	for _, regString := range registryStrings {
-		potentialUpdatesAll = grepFile(filePath, regString)
+		occurrences = append(occurrences, grepFile(filePath, regString)...)
 	}
 
-	// run unique on that string, we'll replace all occurrences
-	// TODO: this may not be desirable, check with real-world results
-	potentialUpdatesAll = common.UniqueStrings(potentialUpdatesAll)
-
-	patternType := strings.Split(updates.PatternString, ":")[0]
-	patternValue := strings.Split(updates.PatternString, ":")[1]
-
-	switch patternType {
-	case "glob":
-		return d.caseGlob(filePath, potentialUpdatesAll, patternValue)
-	case "regex":
-		return d.caseRegex(filePath, potentialUpdatesAll, patternValue)
-	default:
-		logger.Fatalf("Support for this pattern type (%s) does not exist yet (sorry)", patternType)
-		var changeList []ChangeRequest
-		return changeList
-	}
-}
-
-//nolint:dupl //Will be refactored to remove code duplication in next PR
-func (d *Daemon) caseRegex(filePath string, potentialUpdatesAll []string, patternValue string) []ChangeRequest {
 	var changeList []ChangeRequest
-	for _, candidateString := range potentialUpdatesAll {
-		// TODO brute force splitting by ":", this will be a problem with registries with additional :123 ports
-		// EG.. then the split(":") + len() egg.. if the url is localhost:1234/image:tag
-		candidateStringSplit := strings.Split(candidateString, ":")
-		if len(candidateStringSplit) < 2 {
-			logger.Warnw("Refusing to update image",
-				"image", candidateString,
+	for _, group := range groupOccurrences(filePath, occurrences, updates) {
+		tagPolicy, err := policy.New(group.update)
+		if err != nil {
+			logger.Errorw("skipping image, invalid update policy",
+				"image", group.candidate,
 				"file", filePath,
-				"info", "expected the format: '<registry>:<tag>",
-				"len", len(strings.Split(candidateString, ":")),
+				"lines", group.lines,
+				"error", err,
 			)
+			continue
+		}
+		tagPolicy = policy.WithVetoes(tagPolicy, vetoes...)
+		if !group.update.AllowDowngrade {
+			tagPolicy = policy.WithoutDowngrades(tagPolicy)
 		}
-		candidateImage := candidateStringSplit[0]
-
-		// this trick will grab the last slice
-		candidateTag := candidateStringSplit[len(candidateStringSplit)-1]
-		if MatchRegex(candidateTag, patternValue) {
-			index := "created"
-			tagListFromDB := d.registryClient.CachedImagesToTagInfoListSpecificImage(
-				candidateImage,
-				index,
-			)
 
-			// shouldChange is a bool to assist with logic later
-			// changeRequest will go into a []changeList, so we can record it to db one day
-			shouldChange, changeRequest := EvaluateIfImageShouldChangeRegex(
-				candidateTag,
-				tagListFromDB,
-				patternValue,
-				candidateImage,
-				filePath,
-			)
-			if shouldChange {
-				logger.Infow("newer tag detected",
-					"image", changeRequest.File,
-					"old", changeRequest.Old,
-					"new", changeRequest.New,
-				)
+		changeRequest, shouldChange := d.evaluateCandidate(filePath, group.candidate, tagPolicy, blackList)
+		if !shouldChange {
+			continue
+		}
+		changeRequest.Lines = group.lines
+		logger.Infow("newer tag detected",
+			"image", changeRequest.File,
+			"old", changeRequest.Old,
+			"new", changeRequest.New,
+		)
 
-				changeHappened := DoChange(changeRequest)
-				if changeHappened {
-					logger.Debugw("changeList updated with changeRequest",
-						"changeRequest", changeRequest)
-					changeList = append(changeList, changeRequest)
-				}
-			}
-		} else {
-			logger.Debugw("Failed to Match Regex",
-				"candidateImage", candidateImage,
-				"candidateTag", candidateTag,
-				"pattern", patternValue,
-			)
+		changeHappened := DoChange(changeRequest)
+		if changeHappened {
+			logger.Debugw("changeList updated with changeRequest",
+				"changeRequest", changeRequest)
+			changeList = append(changeList, changeRequest)
 		}
 	}
 
@@ -136,209 +134,252 @@ func (d *Daemon) caseRegex(filePath string, potentialUpdatesAll []string, patter
 	return changeList
 }
 
-//nolint:dupl //Will be refactored to remove code duplication in next PR
-func (d *Daemon) caseGlob(filePath string, potentialUpdatesAll []string, patternValue string) []ChangeRequest {
-	var changeList []ChangeRequest
-	// TODO.. whole glob case section to its own function/package
-	for _, candidateString := range potentialUpdatesAll {
-		// TODO brute force splitting by ":", this will be a problem with registries with additional :123 ports
-		// EG.. then the split(":") + len() egg.. if the url is localhost:1234/image:tag
-		candidateStringSplit := strings.Split(candidateString, ":")
-		if len(candidateStringSplit) < 2 {
-			logger.Warnw("Refusing to update image",
-				"image", candidateString,
+// groupOccurrences applies any inline markers, then groups identical candidates sharing the same policy
+// so that each is only evaluated once (and every line it appears on is updated)
+func groupOccurrences(filePath string, occurrences []imageOccurrence, updates cfg.Updates) []*occurrenceGroup {
+	var groups []*occurrenceGroup
+	byKey := map[string]*occurrenceGroup{}
+	for _, o := range occurrences {
+		if o.markerErr != nil {
+			logger.Warnw("skipping image, bad laminar marker",
+				"image", o.candidate,
 				"file", filePath,
-				"info", "expected the format: '<registry>:<tag>",
-				"len", len(strings.Split(candidateString, ":")),
+				"line", o.line,
+				"error", o.markerErr,
 			)
+			continue
+		}
+		update := updates
+		if o.marker != nil {
+			if o.marker.Ignore {
+				logger.Debugw("skipping image, marked laminar:ignore",
+					"image", o.candidate,
+					"file", filePath,
+					"line", o.line,
+				)
+				continue
+			}
+			if o.marker.Pattern != "" {
+				update.PatternString = o.marker.Pattern
+				update.Order = o.marker.Order
+				update.Constraint = o.marker.Constraint
+			}
 		}
-		candidateImage := candidateStringSplit[0]
 
-		// this trick will grab the last slice
-		candidateTag := candidateStringSplit[len(candidateStringSplit)-1]
-		if MatchGlob(candidateTag, patternValue) {
-			// get a full list of tags for the image from our cache
-			index := "created"
-			tagListFromDB := d.registryClient.CachedImagesToTagInfoListSpecificImage(
-				candidateImage,
-				index,
-			)
+		key := strings.Join([]string{o.candidate, update.PatternString, update.Order, update.Constraint}, "\x00")
+		group, ok := byKey[key]
+		if !ok {
+			group = &occurrenceGroup{candidate: o.candidate, update: update}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		if !containsLine(group.lines, o.line) {
+			// overlapping registry strings (or an image used twice on a line) find the same line again
+			group.lines = append(group.lines, o.line)
+		}
+	}
+	return groups
+}
 
-			// shouldChange is a bool to assist with logic later
-			// changeRequest will go into a []changeList, so we can record it to db one day
-			shouldChange, changeRequest := EvaluateIfImageShouldChangeGlob(
-				candidateTag,
-				tagListFromDB,
-				patternValue,
-				candidateImage,
-				filePath,
-			)
-			if shouldChange {
-				logger.Infow("newer tag detected",
-					"image", changeRequest.File,
-					"old", changeRequest.Old,
-					"new", changeRequest.New,
-				)
+func containsLine(lines []int, line int) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
 
-				changeHappened := DoChange(changeRequest)
-				if changeHappened {
-					logger.Debugw("changeList updated with changeRequest",
-						"changeRequest", changeRequest)
-					changeList = append(changeList, changeRequest)
-				}
-			}
-		} else {
-			logger.Debugw("Failed to Match Globs",
+// evaluateCandidate checks a single image string against the policy and the tags in cache
+// the string may be "<image>:<tag>", "<image>:<tag>@sha256:<digest>" or "<image>@sha256:<digest>"
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) evaluateCandidate(
+	filePath string,
+	candidateString string,
+	tagPolicy policy.TagPolicy,
+	blackList *policy.BlackList,
+) (ChangeRequest, bool) {
+	ref, err := imageref.Parse(candidateString)
+	if err != nil || (ref.Tag == "" && ref.Digest == "") {
+		logger.Warnw("Refusing to update image",
+			"image", candidateString,
+			"file", filePath,
+			"info", "expected the format: '<registry>:<tag>' or '<registry>@sha256:<digest>'",
+			"error", err,
+		)
+		return ChangeRequest{}, false
+	}
+	candidateImage, candidateTag, candidateDigest := ref.Name(), ref.Tag, ref.Digest
+	if rule, blocked := blackList.Image(candidateImage); blocked {
+		logger.Infow("skipping blacklisted image",
+			"image", candidateImage,
+			"tag", candidateTag,
+			"file", filePath,
+			"rule", rule,
+		)
+		return ChangeRequest{}, false
+	}
+	if p, pinned, err := d.pinStore.Get(candidateImage); err != nil || pinned {
+		logger.Infow("skipping pinned image",
+			"image", candidateImage,
+			"tag", candidateTag,
+			"file", filePath,
+			"reason", p.Reason,
+			"expires", p.Expires,
+			"error", err,
+		)
+		return ChangeRequest{}, false
+	}
+
+	// get a full list of tags for the image from our cache
+	index := "created"
+	tagListFromDB := d.registryClient.CachedImagesToTagInfoListSpecificImage(
+		candidateImage,
+		index,
+	)
+
+	// digest only references are tracked through the tag (governed by the policy) sharing that digest
+	digestOnly := candidateTag == ""
+	if digestOnly {
+		var found bool
+		if candidateTag, found = d.tagForDigest(tagListFromDB, candidateDigest, tagPolicy); !found {
+			logger.Debugw("no cached tag governed by pattern shares this digest",
 				"candidateImage", candidateImage,
-				"candidateTag", candidateTag,
-				"pattern", patternValue,
+				"candidateDigest", candidateDigest,
+				"patternType", tagPolicy.Type(),
+				"patternValue", tagPolicy.Value(),
 			)
+			return ChangeRequest{}, false
 		}
 	}
 
-	// All changes that occurred will be in this slice
-	// TODO later: record to DB/cache
-	if len(changeList) == 0 {
-		logger.Debugw("no changes done",
-			"changeList", changeList,
-			"filePath", filePath,
-		)
-	} else {
-		logger.Infow("changes to git have happened",
-			"changeList", changeList,
-			"filePath", filePath,
+	if !tagPolicy.Match(candidateTag) {
+		logger.Debugw("Tag not governed by pattern",
+			"candidateImage", candidateImage,
+			"candidateTag", candidateTag,
+			"patternType", tagPolicy.Type(),
+			"patternValue", tagPolicy.Value(),
 		)
+		return ChangeRequest{}, false
 	}
-	return changeList
+
+	// shouldChange is a bool to assist with logic later
+	// changeRequest will go into a []changeList, so we can record it to db one day
+	shouldChange, changeRequest := EvaluateIfImageShouldChange(
+		candidateTag,
+		tagListFromDB,
+		tagPolicy,
+		candidateImage,
+		filePath,
+	)
+	if !shouldChange || candidateDigest == "" {
+		return changeRequest, shouldChange
+	}
+	return withDigests(changeRequest, candidateDigest, digestOnly, tagListFromDB)
 }
 
-// EvaluateIfImageShouldChangeGlob checks if a currentTag should be updated
-// required:
-// - currentTag (string)
-// - []TagInfo list of tags from cache (candidates to be promoted)
-// - glob-string (all tags must match)
-// returns (intent bool, struct ChangeRecord{})
-// ChangeRecord? we can record the ChangeRecord in the DB for potential "undo" button (One day)
-// this function will evaluate "created" timestamps to ensure it has the latest match glob tag
-// NOTE: if the currentTag is not indexed in the cache (eg the tag disappeared from docker registry)
+// tagForDigest returns the most recent tag governed by the policy which points at a digest
+// forbidden (moving) tags are never used
 //
-//	it will assume that **every tag is more recent than the current one**
-func EvaluateIfImageShouldChangeGlob(
-	currentTag string,
-	cachedTagList []registry.TagInfo,
-	patternValue string,
-	image string,
-	file string,
-) (
-	intent bool,
-	cr ChangeRequest,
-) {
-	// first lets just be 100% that the currentTag matches the glob
-	if MatchGlob(currentTag, patternValue) {
-		// This list assumes descending by time, so the first glob match (if any) will be correct
-		for _, potentialTag := range cachedTagList {
-			// check tag matches. also "latest" is forbidden
-			if MatchGlob(potentialTag.Tag, patternValue) && potentialTag.Tag != "latest" {
-				// exclude identical tags from git+registry
-				if potentialTag.Tag != currentTag {
-					cr = ChangeRequest{
-						Old:          currentTag,
-						New:          potentialTag.Tag,
-						Time:         time.Now(),
-						PatternType:  "glob",
-						PatternValue: patternValue,
-						Image:        image,
-						File:         file,
-					}
-					return true, cr
-				}
-				return false, cr
-			}
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) tagForDigest(cachedTagList []registry.TagInfo, digest string, tagPolicy policy.TagPolicy) (string, bool) {
+	for _, t := range cachedTagList {
+		if _, forbidden := d.forbiddenTags.Tag(t.Image, t.Tag); forbidden {
+			continue
+		}
+		if digestOf(t) == digest && tagPolicy.Match(t.Tag) {
+			return t.Tag, true
 		}
-		return false, cr
 	}
-	logger.Warnw("sorry, glob doesn't match",
-		"currentTag", currentTag,
-		"patternValue", patternValue,
-	)
-	return false, cr
+	return "", false
 }
 
-// MatchGlob accepts the input string and then the glob string, returns "if match" bool
-func MatchGlob(input string, globString string) bool {
-	g := glob.MustCompile(globString)
-	return g.Match(input)
+// withDigests adds the old and new digest to a ChangeRequest, the new digest is the Hash of the new tag
+func withDigests(
+	cr ChangeRequest,
+	currentDigest string,
+	digestOnly bool,
+	cachedTagList []registry.TagInfo,
+) (ChangeRequest, bool) {
+	newDigest := ""
+	for _, t := range cachedTagList {
+		if t.Tag == cr.New {
+			newDigest = digestOf(t)
+			break
+		}
+	}
+	if newDigest == "" {
+		logger.Warnw("Refusing to update image, no digest known for the new tag",
+			"image", cr.Image,
+			"old", cr.Old,
+			"new", cr.New,
+		)
+		return cr, false
+	}
+	if digestOnly && newDigest == currentDigest {
+		// the newer tag points to the same image, a tag+digest reference still moves to the newer tag
+		return cr, false
+	}
+	cr.OldDigest = currentDigest
+	cr.NewDigest = newDigest
+	cr.DigestOnly = digestOnly
+	return cr, true
 }
 
-// MatchRegex accepts a regex pattern then an input string
-func MatchRegex(input string, regexPattern string) bool {
-	match, err := regexp.MatchString(regexPattern, input)
-	if err != nil {
-		logger.Fatal("bad regex supplied or something? I have no idea sorry")
+// digestOf returns the "sha256:<hex>" digest of a cached tag ("" if unknown)
+// NOTE: workers store the Hash without the algorithm prefix
+func digestOf(t registry.TagInfo) string {
+	if t.Hash == "" {
+		return ""
 	}
-
-	logger.Debugw("comparing regex:",
-		"input", input,
-		"pattern", regexPattern,
-		"result", match,
-	)
-	return match
+	return "sha256:" + strings.TrimPrefix(t.Hash, "sha256:")
 }
 
-// EvaluateIfImageShouldChangeRegex checks if a currentTag should be updated
+// EvaluateIfImageShouldChange checks if a currentTag should be updated
 // required:
 // - currentTag (string)
 // - []TagInfo list of tags from cache (candidates to be promoted)
-// - glob-string (all tags must match)
+// - TagPolicy (decides which of the candidates is preferred)
 // returns (intent bool, struct ChangeRecord{})
-// ChangeRecord? we can record the ChangeRecord in the DB for potential "undo" button
-// this function will evaluate "created" timestamps to ensure it has the latest match glob tag
-// NOTE: if the currentTag is not indexed in the cache (eg the tag disappeared from docker registry)
-//
-//	it will assume that **every tag is more recent than the current one**
-func EvaluateIfImageShouldChangeRegex(
+// ChangeRecord? we can record the ChangeRecord in the DB for potential "undo" button (One day)
+// NOTE: downgrades (including when the currentTag is no longer in the cache) are prevented by wrapping
+// the TagPolicy with policy.WithoutDowngrades, this function only trusts the TagPolicy
+func EvaluateIfImageShouldChange(
 	currentTag string,
 	cachedTagList []registry.TagInfo,
-	patternValue string,
+	tagPolicy policy.TagPolicy,
 	image string,
 	file string,
 ) (
 	intent bool,
 	cr ChangeRequest,
 ) {
-	// first lets just be 100% that the currentTag matches the glob
-	if MatchRegex(
-		currentTag,
-		patternValue) {
-		// This list assumes descending by time, so the first glob match (if any) will be correct
-		for _, potentialTag := range cachedTagList {
-			// check tag matches. also "latest" is forbidden
-			if MatchRegex(
-				potentialTag.Tag,
-				patternValue) && potentialTag.Tag != "latest" { // exclude identical tags from git+registry
-				if potentialTag.Tag != currentTag {
-					cr = ChangeRequest{
-						Old:          currentTag,
-						New:          potentialTag.Tag,
-						Time:         time.Now(),
-						PatternType:  "regex",
-						PatternValue: patternValue,
-						Image:        image,
-						File:         file,
-					}
-					return true, cr
-				}
-				return false, cr
-			}
-		}
+	// first lets just be 100% that the currentTag is governed by the policy
+	if !tagPolicy.Match(currentTag) {
+		logger.Warnw("sorry, pattern doesn't match",
+			"currentTag", currentTag,
+			"patternType", tagPolicy.Type(),
+			"patternValue", tagPolicy.Value(),
+		)
+		return false, cr
+	}
 
+	potentialTag, found := tagPolicy.Select(currentTag, cachedTagList)
+	// exclude identical tags from git+registry
+	if !found || potentialTag.Tag == currentTag {
 		return false, cr
 	}
-	logger.Warnw("sorry, regex doesn't match",
-		"currentTag", currentTag,
-		"patternValue", patternValue,
-	)
-	return false, cr
+	cr = ChangeRequest{
+		Old:          currentTag,
+		New:          potentialTag.Tag,
+		Time:         time.Now(),
+		PatternType:  tagPolicy.Type(),
+		PatternValue: tagPolicy.Value(),
+		Image:        image,
+		File:         file,
+	}
+	return true, cr
 }
 
 // ReadFile will return the raw []byte content of a file
@@ -351,9 +392,9 @@ func ReadFile(filePath string) ([]byte, string) {
 	return r, stringContents
 }
 
-// grepFile returns a slice of hits that match a string inside a file
+// grepFile returns every occurrence of a string inside a file (with its line number and any laminar marker)
 // The assumption is that this is only used against YAML files
-func grepFile(file string, searchString string) (matches []string) {
+func grepFile(file string, searchString string) (matches []imageOccurrence) {
 	pat := []byte(searchString)
 	f, err := os.Open(file)
 	if err != nil {
@@ -366,17 +407,26 @@ func grepFile(file string, searchString string) (matches []string) {
 		}
 	}(f)
 	scanner := bufio.NewScanner(f)
+	lineNumber := 0
 	for scanner.Scan() {
+		lineNumber++
 		if bytes.Contains(scanner.Bytes(), pat) {
+			// markers are comments, only the content before them is searched for images
+			content, marker, markerErr := parseMarker(scanner.Text())
+
 			// if this matches we know the string is somewhere **within a line of text**
 			// we should split that line of text (strings.Fields) and range over those to ensure that we
 			// don't count the entire line as the actual hit
 			// This should be enough for yaml (although I imagine it would also detect stuff in comments)
 			// but it would be madness for a json file for example.
-			for _, field := range strings.Fields(scanner.Text()) {
+			for _, field := range strings.Fields(content) {
 				if bytes.Contains([]byte(field), pat) {
-					// val := strings.Fields(scanner.Text())[1]
-					matches = append(matches, field)
+					matches = append(matches, imageOccurrence{
+						candidate: imageref.Trim(field),
+						line:      lineNumber,
+						marker:    marker,
+						markerErr: markerErr,
+					})
 					logger.Debug(field)
 				}
 			}
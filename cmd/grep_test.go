@@ -1 +1,237 @@
 package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/pin"
+	"github.com/digtux/laminar/pkg/policy"
+	"github.com/digtux/laminar/pkg/registry"
+	"github.com/tidwall/buntdb"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestEvaluateIfImageShouldChange(t *testing.T) {
+	// newest push first, as returned by the "created" index
+	cached := []registry.TagInfo{
+		{Tag: "latest"},
+		{Tag: "develop-ccc"},
+		{Tag: "1.4.10"},
+		{Tag: "develop-bbb"},
+		{Tag: "1.4.2"},
+	}
+	evaluateTests := []struct {
+		current  string
+		pattern  string
+		intent   bool
+		expected string
+	}{
+		{"develop-bbb", "glob:develop-*", true, "develop-ccc"},
+		{"develop-ccc", "glob:develop-*", false, ""},
+		{"develop-aaa", "regex:^develop-", true, "develop-ccc"},
+		{"1.4.2", "semver:~1.4", true, "1.4.10"},
+		{"develop-bbb", "semver:~1.4", false, ""},
+		{"master-aaa", "glob:develop-*", false, ""},
+	}
+	for _, test := range evaluateTests {
+		p, err := policy.Parse(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		intent, cr := EvaluateIfImageShouldChange(test.current, cached, p, "reg/app", "file.yaml")
+		if intent != test.intent || cr.New != test.expected {
+			t.Errorf("EvaluateIfImageShouldChange(%s, %s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.current, test.pattern, intent, cr.New, test.intent, test.expected)
+		}
+	}
+}
+
+func TestDoUpdateWithMarkers(t *testing.T) {
+	db := cache.Open(":memory:")
+	now := time.Now()
+	for i, tag := range []string{"develop-aaa", "develop-bbb", "2.0.0", "2.3.1", "3.0.0"} {
+		for _, image := range []string{"reg/acme/api", "reg/acme/web"} {
+			registry.TagInfoToCache(registry.TagInfo{
+				Image:   image,
+				Hash:    tag,
+				Tag:     tag,
+				Created: now.Add(time.Duration(i) * time.Minute),
+			}, db)
+		}
+	}
+	pins := pin.New(db)
+	if _, err := pins.Add("reg/acme/web", "incident", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	err := os.WriteFile(file, []byte(`api: reg/acme/api:develop-aaa
+web: reg/acme/web:develop-aaa
+pinned: reg/acme/api:develop-aaa # laminar:ignore
+stable: reg/acme/api:2.0.0 # laminar: {"pattern":"semver:^2"}
+broken: reg/acme/api:develop-aaa # laminar: {"pattern":
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{registryClient: registry.New(db), pinStore: pins}
+	changes := d.doUpdate(file, cfg.Updates{PatternString: "glob:develop-*"}, []string{"reg/acme"})
+	if len(changes) != 2 {
+		t.Errorf("expected 2 changes, got: %v", changes)
+	}
+
+	_, contents := ReadFile(file)
+	expected := `api: reg/acme/api:develop-bbb
+web: reg/acme/web:develop-aaa
+pinned: reg/acme/api:develop-aaa # laminar:ignore
+stable: reg/acme/api:2.3.1 # laminar: {"pattern":"semver:^2"}
+broken: reg/acme/api:develop-aaa # laminar: {"pattern":
+`
+	if contents != expected {
+		t.Errorf("unexpected file contents after doUpdate, got:\n%s\nexpected:\n%s", contents, expected)
+	}
+}
+
+func TestParseMarker(t *testing.T) {
+	markerTests := []struct {
+		line    string
+		content string
+		marker  *lineMarker
+		valid   bool
+	}{
+		{"image: reg/app:1.0", "image: reg/app:1.0", nil, true},
+		{"image: reg/app:1.0 # laminar:ignore", "image: reg/app:1.0 ", &lineMarker{Ignore: true}, true},
+		{`image: reg/app:1.0 #laminar: {"pattern":"semver:^1"}`, "image: reg/app:1.0 ", &lineMarker{Pattern: "semver:^1"}, true},
+		{`image: reg/app:1.0 # laminar: {"patern":"semver:^1"}`, "image: reg/app:1.0 ", nil, false},
+		{"image: reg/app:1.0 # laminar: yes", "image: reg/app:1.0 ", nil, false},
+	}
+	for _, test := range markerTests {
+		content, marker, err := parseMarker(test.line)
+		if content != test.content || !reflect.DeepEqual(marker, test.marker) || (err == nil) != test.valid {
+			t.Errorf("parseMarker(%s), got: ('%s', %+v, %v)", test.line, content, marker, err)
+		}
+	}
+}
+
+// seedTags caches tags of reg/acme/api (unless another Image is set), each created a minute after the previous
+func seedTags(db *buntdb.DB, tags ...registry.TagInfo) {
+	now := time.Now()
+	for i, info := range tags {
+		if info.Image == "" {
+			info.Image = "reg/acme/api"
+		}
+		info.Created = now.Add(time.Duration(i-len(tags)) * time.Minute)
+		registry.TagInfoToCache(info, db)
+	}
+}
+
+// writeValues writes a temporary values file
+func writeValues(t *testing.T, contents string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDoUpdate(t *testing.T) {
+	digest := func(i int) string { return fmt.Sprintf("sha256:%064d", i) }
+	hash := func(i int) string { return fmt.Sprintf("%064d", i) }
+
+	doUpdateTests := []struct {
+		name      string
+		tags      []registry.TagInfo // oldest first
+		forbidden []string           // forbiddenTags of the reg/acme registry, "*latest" is forbidden globally
+		regs      []string           // registry strings, default: reg/acme
+		contents  string
+		changes   int
+		expected  string
+	}{
+		{
+			name: "digests",
+			tags: []registry.TagInfo{{Tag: "develop-1", Hash: hash(1)}, {Tag: "develop-2", Hash: hash(2)}},
+			contents: "tagged: reg/acme/api:develop-1@" + digest(1) + "\n" +
+				"immutable: reg/acme/api@" + digest(1) + "\n",
+			changes: 2,
+			expected: "tagged: reg/acme/api:develop-2@" + digest(2) + "\n" +
+				"immutable: reg/acme/api@" + digest(2) + "\n",
+		},
+		{
+			// develop-3 is a re-tag of develop-2, a tag+digest reference still moves to the newer tag
+			name: "same digest",
+			tags: []registry.TagInfo{{Tag: "develop-2", Hash: hash(2)}, {Tag: "develop-3", Hash: hash(2)}},
+			contents: "tagged: reg/acme/api:develop-2@" + digest(2) + "\n" +
+				"immutable: reg/acme/api@" + digest(2) + "\n",
+			changes: 1,
+			expected: "tagged: reg/acme/api:develop-3@" + digest(2) + "\n" +
+				"immutable: reg/acme/api@" + digest(2) + "\n",
+		},
+		{
+			// moving tags are pushed last, so would otherwise win
+			// the digest only reference points at a forbidden tag, so there's no tag to track it by
+			name: "forbidden tags",
+			tags: []registry.TagInfo{
+				{Tag: "develop-1", Hash: hash(1)},
+				{Tag: "develop-2", Hash: hash(2)},
+				{Tag: "develop-nightly", Hash: hash(3)},
+				{Tag: "develop-latest", Hash: hash(4)},
+			},
+			forbidden: []string{"*-nightly"},
+			contents: "api: reg/acme/api:develop-1\n" +
+				"immutable: reg/acme/api@" + digest(4) + "\n",
+			changes: 1,
+			expected: "api: reg/acme/api:develop-2\n" +
+				"immutable: reg/acme/api@" + digest(4) + "\n",
+		},
+		{
+			// both registry strings find every line, the new tag starts with the old one
+			name: "overlapping registries",
+			tags: []registry.TagInfo{{Tag: "develop-1", Hash: hash(1)}, {Tag: "develop-100", Hash: hash(2)}},
+			regs: []string{"reg/acme", "reg/acme/api"},
+			contents: "api: reg/acme/api:develop-1\n" +
+				"cmd: reg/acme/api:develop-1 reg/acme/api:develop-1\n",
+			changes: 1,
+			expected: "api: reg/acme/api:develop-100\n" +
+				"cmd: reg/acme/api:develop-100 reg/acme/api:develop-100\n",
+		},
+	}
+	for _, test := range doUpdateTests {
+		db := cache.Open(":memory:")
+		seedTags(db, test.tags...)
+		forbiddenTags, err := policy.NewForbiddenTags([]string{"*latest"}, []cfg.DockerRegistry{
+			{Reg: "reg/acme", ForbiddenTags: test.forbidden},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := writeValues(t, test.contents)
+
+		d := &Daemon{registryClient: registry.New(db), pinStore: pin.New(db), forbiddenTags: forbiddenTags}
+		regs := test.regs
+		if regs == nil {
+			regs = []string{"reg/acme"}
+		}
+		changes := d.doUpdate(file, cfg.Updates{PatternString: "glob:develop-*"}, regs)
+		if len(changes) != test.changes {
+			t.Errorf("doUpdate(%s), got: %d changes but expected: %d", test.name, len(changes), test.changes)
+		}
+		if _, contents := ReadFile(file); contents != test.expected {
+			t.Errorf("doUpdate(%s), got:\n%s\nexpected:\n%s", test.name, contents, test.expected)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// markerRegex finds inline laminar comments, EG: `# laminar: {"pattern":"semver:^2"}` or `# laminar:ignore`
+var markerRegex = regexp.MustCompile(`#\s*laminar:\s*(.*)$`)
+
+// lineMarker overrides the file-level update policy for the images on a single line
+// blacklist, minAge and promoteFrom of the file-level policy still apply
+type lineMarker struct {
+	Ignore     bool   `json:"ignore"`
+	Pattern    string `json:"pattern"`
+	Order      string `json:"order"`
+	Constraint string `json:"constraint"`
+}
+
+// parseMarker splits a line into the content before any laminar marker and the marker itself
+// marker is nil if the line has no marker
+func parseMarker(line string) (content string, marker *lineMarker, err error) {
+	loc := markerRegex.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return line, nil, nil
+	}
+	content = line[:loc[0]]
+	value := strings.TrimSpace(line[loc[2]:loc[3]])
+
+	marker = &lineMarker{}
+	switch {
+	case value == "ignore":
+		marker.Ignore = true
+	case strings.HasPrefix(value, "{"):
+		decoder := json.NewDecoder(bytes.NewBufferString(value))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(marker); err != nil {
+			return content, nil, errors.Wrapf(err, "invalid laminar marker %q", value)
+		}
+	default:
+		return content, nil, errors.Errorf("invalid laminar marker %q, expected 'ignore' or a JSON object", value)
+	}
+	return content, marker, nil
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digtux/laminar/pkg/pin"
+	"github.com/digtux/laminar/pkg/web"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pinAddress  string        // laminar web address the pin subcommands talk to
+	pinToken    string        // global.apiToken of laminar, required to add and remove pins
+	pinReason   string        // why an image is pinned
+	pinDuration time.Duration // how long until a pin expires (0 = never)
+)
+
+var pinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "freeze images at their current tag (talks to a running laminar)",
+	Long: `Pinned images are skipped by laminar until they are unpinned (or the pin expires).
+
+Pins are stored in the laminar cache, use a file-backed --cache for them to survive restarts.`,
+}
+
+var pinAddCmd = &cobra.Command{
+	Use:     "add <image>",
+	Short:   "pin an image at its current tag",
+	Example: "  laminar pin add 112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp/api --reason 'INC-123' --for 2h",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		body, err := json.Marshal(web.PinRequestJSON{
+			Image:    args[0],
+			Reason:   pinReason,
+			Duration: durationString(pinDuration),
+		})
+		if err != nil {
+			return err
+		}
+		var p pin.Pin
+		if err := pinRequest(cmd.Context(), http.MethodPost, "/pins", body, &p); err != nil {
+			return err
+		}
+		printPins([]pin.Pin{p})
+		return nil
+	},
+}
+
+var pinListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list pinned images",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var pins []pin.Pin
+		if err := pinRequest(cmd.Context(), http.MethodGet, "/pins", nil, &pins); err != nil {
+			return err
+		}
+		printPins(pins)
+		return nil
+	},
+}
+
+var pinRemoveCmd = &cobra.Command{
+	Use:     "rm <image>",
+	Aliases: []string{"remove", "unpin"},
+	Short:   "unpin an image",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := "/pins?image=" + url.QueryEscape(args[0])
+		if err := pinRequest(cmd.Context(), http.MethodDelete, path, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("unpinned %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	pinCmd.PersistentFlags().StringVar(&pinAddress, "address", "http://localhost:8080", "address of the laminar web listener")
+	pinCmd.PersistentFlags().StringVar(&pinToken, "token", "", "global.apiToken of laminar, required by add and rm (default: $LAMINAR_API_TOKEN)")
+	pinAddCmd.Flags().StringVar(&pinReason, "reason", "", "why the image is pinned")
+	pinAddCmd.Flags().DurationVar(&pinDuration, "for", 0, "expire the pin after this long. EG: 30m, 2h (default: never)")
+	for _, c := range []*cobra.Command{pinAddCmd, pinListCmd, pinRemoveCmd} {
+		// errors are API responses, Execute() prints them once and usage isn't helpful
+		c.SilenceUsage = true
+		c.SilenceErrors = true
+		pinCmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(pinCmd)
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// pinRequest calls the laminar web API, decoding a JSON response into result (if not nil)
+func pinRequest(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, pinAddress+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token := pinToken
+	if token == "" {
+		token = os.Getenv("LAMINAR_API_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "is laminar running? (see --address)")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("laminar responded with %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func printPins(pins []pin.Pin) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tREASON\tPINNED\tEXPIRES")
+	for _, p := range pins {
+		expires := "never"
+		if p.Expires != nil {
+			expires = p.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Image, p.Reason, p.Created.Format(time.RFC3339), expires)
+	}
+	w.Flush()
+}
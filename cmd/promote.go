@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/imageref"
+	"github.com/pkg/errors"
+)
+
+// validatePromoteFrom ensures a promoteFrom block references something that exists
+func validatePromoteFrom(repo cfg.GitRepo, update cfg.Updates) error {
+	if update.PromoteFrom == nil {
+		return nil
+	}
+	if len(update.PromoteFrom.Files) == 0 && update.PromoteFrom.Update == "" {
+		return errors.New("promoteFrom requires either files or update")
+	}
+	if update.PromoteFrom.Update != "" && len(promoteFromUpdateFiles(repo, update.PromoteFrom.Update)) == 0 {
+		return errors.Errorf("promoteFrom update %q does not exist (or has no files)", update.PromoteFrom.Update)
+	}
+	return nil
+}
+
+func promoteFromUpdateFiles(repo cfg.GitRepo, name string) []cfg.Files {
+	for _, u := range repo.Updates {
+		if u.Name == name {
+			return u.Files
+		}
+	}
+	return nil
+}
+
+// findTagsInFiles returns all the tags used for an image within some files
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) findTagsInFiles(files []string, image string) map[string]bool {
+	result := map[string]bool{}
+	for _, file := range files {
+		for _, hit := range d.opsClient.Search(file, image+":") {
+			ref, err := imageref.Parse(imageref.Trim(hit))
+			if err == nil && ref.Name() == image && ref.Tag != "" {
+				result[ref.Tag] = true
+			}
+		}
+	}
+	return result
+}
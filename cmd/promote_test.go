@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/digtux/laminar/pkg/operations"
+)
+
+func TestFindTagsInFiles(t *testing.T) {
+	staging := filepath.Join(t.TempDir(), "images-staging.yml")
+	err := os.WriteFile(staging, []byte(`
+api: reg/acme/api:master-111
+api-worker: "reg/acme/api-worker:master-999"
+web: 'reg/acme/web:master-222@sha256:8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b',
+api-canary: reg/acme/api:master-112 # canary
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{opsClient: operations.New()}
+	tags := d.findTagsInFiles([]string{staging}, "reg/acme/api")
+	expected := map[string]bool{"master-111": true, "master-112": true}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("findTagsInFiles, got: %v but expected: %v", tags, expected)
+	}
+	tags = d.findTagsInFiles([]string{staging}, "reg/acme/web")
+	if !reflect.DeepEqual(tags, map[string]bool{"master-222": true}) {
+		t.Errorf("findTagsInFiles expected the digest to be stripped, got: %v", tags)
+	}
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/gitoperations"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/policy"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+// promoteFromVeto returns a Veto which only allows tags already referenced (for the same image)
+// in the promoteFrom source files, EG: prod only ever picks tags already live in images-staging.yml
+// it's nil when the update doesn't ask for it (doUpdate skips nil vetoes)
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) promoteFromVeto(gitRepo cfg.GitRepo, update cfg.Updates) policy.Veto {
+	if update.PromoteFrom == nil {
+		return nil
+	}
+	sources := append([]cfg.Files{}, update.PromoteFrom.Files...)
+	if update.PromoteFrom.Update != "" {
+		sources = append(sources, promoteFromUpdateFiles(gitRepo, update.PromoteFrom.Update)...)
+	}
+
+	var sourceFiles []string
+	for _, p := range sources {
+		realPath := fmt.Sprintf("%s/%s", gitoperations.GetRepoPath(gitRepo), p.Path)
+		sourceFiles = append(sourceFiles, d.opsClient.FindFiles(realPath)...)
+	}
+
+	// tags live in the source files, per image (searched lazily and only once per run)
+	liveTags := map[string]map[string]bool{}
+	return func(candidate registry.TagInfo) (string, bool) {
+		tags, ok := liveTags[candidate.Image]
+		if !ok {
+			tags = d.findTagsInFiles(sourceFiles, candidate.Image)
+			liveTags[candidate.Image] = tags
+			logger.Debugw("promoteFrom source tags",
+				"image", candidate.Image,
+				"tags", tags,
+				"sourceFiles", sourceFiles,
+			)
+		}
+		if tags[candidate.Tag] {
+			return "", false
+		}
+		return fmt.Sprintf("not referenced in promoteFrom source files %v", sources), true
+	}
+}
+
+// platformsVeto rejects candidates whose manifest (list) doesn't cover every required platform
+// EG: a tag without linux/arm64 would break arm64 node pools
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) platformsVeto(required []string) policy.Veto {
+	if len(required) == 0 {
+		return nil
+	}
+	return func(candidate registry.TagInfo) (string, bool) {
+		available, err := d.registryClient.CachedPlatforms(context.Background(), candidate)
+		if err != nil {
+			return fmt.Sprintf("unable to check platforms: %v", err), true
+		}
+		if missing := registry.MissingPlatforms(required, available); len(missing) > 0 {
+			return fmt.Sprintf("missing platforms %v (available: %v)", missing, available), true
+		}
+		return "", false
+	}
+}
+
+// labelsVeto rejects candidates whose image config lacks one of the required labels (or has another value)
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) labelsVeto(required map[string]string) policy.Veto {
+	if len(required) == 0 {
+		return nil
+	}
+	return func(candidate registry.TagInfo) (string, bool) {
+		labels, err := d.registryClient.CachedLabels(context.Background(), candidate)
+		if err != nil {
+			return fmt.Sprintf("unable to read labels: %v", err), true
+		}
+		if mismatched := registry.MismatchedLabels(required, labels); len(mismatched) > 0 {
+			return fmt.Sprintf("labels don't match %v", mismatched), true
+		}
+		return "", false
+	}
+}
+
+// signatureVeto only allows candidates with a valid cosign signature by one of the keys
+// if the keys can't be loaded every candidate is rejected
+//
+//goland:noinspection GoMixedReceiverTypes
+func (d *Daemon) signatureVeto(keyFiles []string) policy.Veto {
+	if len(keyFiles) == 0 {
+		return nil
+	}
+	keys, err := registry.LoadPublicKeys(keyFiles)
+	if err != nil {
+		// validated during config load, but the files may have gone since: fail closed
+		logger.Errorw("unable to load signature keys",
+			"signatureKeys", keyFiles,
+			"error", err,
+		)
+		return func(registry.TagInfo) (string, bool) {
+			return fmt.Sprintf("unable to load signature keys: %v", err), true
+		}
+	}
+	return func(candidate registry.TagInfo) (string, bool) {
+		err := d.registryClient.CachedVerifySignature(context.Background(), candidate, keyFiles, keys)
+		switch {
+		case err == nil:
+			return "", false
+		case errors.Is(err, registry.ErrUnsigned):
+			return "unsigned", true
+		case errors.Is(err, registry.ErrInvalidSignature):
+			return fmt.Sprintf("bad signature: %v", err), true
+		}
+		return fmt.Sprintf("unable to check signature: %v", err), true
+	}
+}
@@ -4,8 +4,10 @@ go 1.19
 
 require (
 	cloud.google.com/go/artifactregistry v1.11.2
+	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/aws/aws-sdk-go v1.44.219
 	github.com/creasty/defaults v1.7.0
+	github.com/docker/cli v20.10.20+incompatible
 	github.com/go-git/go-git/v5 v5.6.0
 	github.com/gobwas/glob v0.2.3
 	github.com/google/go-containerregistry v0.13.0
@@ -18,7 +20,9 @@ require (
 	github.com/tidwall/buntdb v1.2.10
 	go.uber.org/zap v1.24.0
 	golang.org/x/crypto v0.7.0
+	golang.org/x/time v0.3.0
 	google.golang.org/api v0.110.0
+	google.golang.org/protobuf v1.28.1
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0
 )
 
@@ -35,7 +39,6 @@ require (
 	github.com/acomagu/bufpipe v1.0.4 // indirect
 	github.com/cloudflare/circl v1.3.2 // indirect
 	github.com/containerd/stargz-snapshotter/estargz v0.12.1 // indirect
-	github.com/docker/cli v20.10.20+incompatible // indirect
 	github.com/docker/distribution v2.8.1+incompatible // indirect
 	github.com/docker/docker v20.10.20+incompatible // indirect
 	github.com/docker/docker-credential-helpers v0.7.0 // indirect
@@ -83,11 +86,9 @@ require (
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230222225845-10f96fb3dbec // indirect
 	google.golang.org/grpc v1.53.0 // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 )
@@ -64,8 +64,9 @@ func TestParseConfigFailure(t *testing.T) {
 	testData := []byte(`...garbage...`)
 	empty := Config{
 		Global: Global{
-			WebAddress: ":8080",
-			WebDebug:   false,
+			WebAddress:    ":8080",
+			WebDebug:      false,
+			ForbiddenTags: []string{"latest"},
 		},
 	}
 	result, err := ParseConfig(testData)
@@ -1,5 +1,7 @@
 package cfg
 
+import "time"
+
 // Global settings such as git commit user/email
 type Global struct {
 	// NOTE: when adding 'default' fields here please update TestParseConfigFailure in config_test.go
@@ -9,6 +11,10 @@ type Global struct {
 	GitHubToken string      `yaml:"gitHubToken"` // allow inbound webhooks from GitHub
 	WebAddress  string      `yaml:"webAddress" default:":8080"`
 	WebDebug    bool        `yaml:"webDebug" default:"false"`
+	// APIToken is required (as "Authorization: Bearer <apiToken>") to change pins, they can't be changed without it
+	APIToken string `yaml:"apiToken"`
+	// ForbiddenTags are globs of tags which are never written into git (EG: moving tags)
+	ForbiddenTags []string `yaml:"forbiddenTags" default:"[\"latest\"]"`
 }
 
 // Config is the top level of config
@@ -20,13 +26,46 @@ type Config struct {
 
 // DockerRegistry contains info about the docker registries
 type DockerRegistry struct {
-	Reg     string `yaml:"reg"`
-	Name    string `yaml:"name"`
-	TimeOut int    `yaml:"timeOut,omitempty"`
+	Reg  string `yaml:"reg"`
+	Name string `yaml:"name"`
+	// Type (optional) selects the worker: ecr, gar, gcr or oci (guessed from the reg when unset)
+	Type string `yaml:"type,omitempty"`
+	// TimeOut (seconds, default 30) is the deadline of each registry API call
+	TimeOut int `yaml:"timeOut,omitempty"`
+	// MaxConcurrency (default 4) is how many images of this registry are scanned at once
+	MaxConcurrency int `yaml:"maxConcurrency,omitempty"`
+	// RequestsPerSecond (optional) limits the API calls made to this registry
+	RequestsPerSecond float64 `yaml:"requestsPerSecond,omitempty"`
+	// ForbiddenTags are globs of tags never written into git for this registry, in addition to the global ones
+	ForbiddenTags []string `yaml:"forbiddenTags,omitempty"`
+	// FullScan (Google Artifact Registry only) lists every image of every repository in the project
+	// instead of only the images referenced in git
+	FullScan bool `yaml:"fullScan,omitempty"`
+	// Auth (optional) are the credentials for this registry, without it the ambient credentials are used
+	Auth *RegistryAuth `yaml:"auth,omitempty"`
+}
+
+// RegistryAuth configures the credentials of a single registry, only one kind may be set
+// file paths may start with "~/"
+type RegistryAuth struct {
+	Username     string `yaml:"username,omitempty"`     // basic auth username
+	Password     string `yaml:"password,omitempty"`     // basic auth password (prefer passwordFile)
+	PasswordFile string `yaml:"passwordFile,omitempty"` // basic auth password, read from a file
+	TokenFile    string `yaml:"tokenFile,omitempty"`    // bearer token, re-read on every request
+	DockerConfig string `yaml:"dockerConfig,omitempty"` // path to a docker config.json
+	CredHelper   string `yaml:"credHelper,omitempty"`   // docker credential helper, EG: "ecr-login" for docker-credential-ecr-login
+	AWSProfile   string `yaml:"awsProfile,omitempty"`   // ECR: profile in the shared AWS config
+	AWSRoleARN   string `yaml:"awsRoleArn,omitempty"`   // ECR: role to assume (with the awsProfile, or ambient credentials)
+	GCPKeyFile   string `yaml:"gcpKeyFile,omitempty"`   // GCR/GAR: service account JSON key
 }
 
+// BlackList excludes images and/or tags from promotion
+// values use the same "<type>:<value>" form as update patterns (glob or regex), EG: "glob:*/legacy-*"
+// when several fields are set on one entry they must all match
 type BlackList struct {
-	Pattern string `yaml:"pattern"`
+	Image   string `yaml:"image,omitempty"`   // matched against the image name (without the tag)
+	Tag     string `yaml:"tag,omitempty"`     // matched against tags which could be promoted to
+	Pattern string `yaml:"pattern,omitempty"` // matched against the full "<image>:<tag>" string
 }
 
 // GitRepo which laminar operates on
@@ -53,11 +92,46 @@ type Files struct {
 	Path string `yaml:"path"`
 }
 
+// PromoteFrom points at the files of another environment, EG: prod only promotes tags live in staging
+type PromoteFrom struct {
+	Files  []Files `yaml:"files,omitempty"`  // files (or directories) in the same git repo
+	Update string  `yaml:"update,omitempty"` // name of another update policy, its files are used
+}
+
+// SourceRepo is an application git repo, its branch history orders tags built from its commits
+type SourceRepo struct {
+	URL    string `yaml:"url"`
+	Branch string `yaml:"branch"`
+	Key    string `yaml:"key,omitempty"` // ssh private key, no auth is used when empty
+	// Depth (optional) limits the history cloned to the most recent commits, older commit tags aren't ranked
+	Depth int `yaml:"depth,omitempty"`
+}
+
 // Updates contains instructions about what to do with matching image
 type Updates struct {
+	Name          string      `yaml:"name,omitempty"` // optional, allows referencing from promoteFrom
 	PatternString string      `yaml:"pattern"`
 	Files         []Files     `yaml:"files"`
 	BlackList     []BlackList `yaml:"blacklist"`
+	// Order (regex only) ranks tags by the value of the first named capture group
+	// instead of the "created" timestamp. Either "numerical" or "alphabetical"
+	Order string `yaml:"order,omitempty"`
+	// Constraint (calver only) limits candidates relative to the current tag, "sameYear" or "sameMajor"
+	Constraint string `yaml:"constraint,omitempty"`
+	// SourceRepo (commit only) is the application repo whose branch history orders the commit tags
+	SourceRepo *SourceRepo `yaml:"sourceRepo,omitempty"`
+	// MinAge is how long ago a tag must have been created before it may be promoted, EG: "30m"
+	MinAge time.Duration `yaml:"minAge,omitempty"`
+	// PromoteFrom (optional) restricts candidates to tags already referenced in these files
+	PromoteFrom *PromoteFrom `yaml:"promoteFrom,omitempty"`
+	// AllowDowngrade permits promoting to tags older than the current one (or when the current tag is unknown)
+	AllowDowngrade bool `yaml:"allowDowngrade,omitempty"`
+	// Platforms (EG: "linux/arm64") a candidate's manifest list must include before it may be promoted
+	Platforms []string `yaml:"platforms,omitempty"`
+	// SignatureKeys are paths to cosign public keys, when set a candidate needs a valid signature by one of them
+	SignatureKeys []string `yaml:"signatureKeys,omitempty"`
+	// Labels a candidate's image config must carry (with exactly these values), EG: {com.acme.tests: passed}
+	Labels map[string]string `yaml:"labels,omitempty"`
 }
 
 type RemoteUpdates struct {
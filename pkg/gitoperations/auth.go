@@ -1,41 +1,56 @@
 package gitoperations
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/digtux/laminar/pkg/common"
 	"github.com/digtux/laminar/pkg/logger"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	cryptossh "golang.org/x/crypto/ssh"
 )
 
-func (c *Client) getSSHKeySigner(fileName string) cryptossh.Signer {
+func (c *Client) getSSHKeySigner(fileName string) (cryptossh.Signer, error) {
 	fullPath := common.GetFileAbsPath(fileName)
 	sshKey, err := os.ReadFile(fullPath)
 	if err != nil {
-		logger.Fatalw("unable to read private ssh key",
-			"file", fileName,
-			"error", err,
-		)
+		return nil, fmt.Errorf("unable to read private ssh key %s: %w", fileName, err)
 	}
 
 	signer, err := cryptossh.ParsePrivateKey(sshKey)
 	if err != nil {
-		logger.Fatalw("Failed to parse ssh key",
-			"action", "sshKeyParse",
-			"sshKey", fileName,
-			"error", err.Error(),
-		)
+		return nil, fmt.Errorf("failed to parse ssh key %s: %w", fileName, err)
 	}
-	return signer
+	return signer, nil
 }
 
-func (c *Client) getAuth(key string) *ssh.PublicKeys {
-	signer := c.getSSHKeySigner(key)
+// authMethod returns ssh auth for a private key file
+// without a key no auth is used, EG: for public or local (file) repos
+func (c *Client) authMethod(key string) (transport.AuthMethod, error) {
+	if key == "" {
+		return nil, nil
+	}
+	signer, err := c.getSSHKeySigner(key)
+	if err != nil {
+		return nil, err
+	}
 	auth := &ssh.PublicKeys{
 		User:   "git",
 		Signer: signer,
 	}
 	// auth.HostKeyCallback = cryptossh.InsecureIgnoreHostKey()
+	return auth, nil
+}
+
+func (c *Client) getAuth(key string) transport.AuthMethod {
+	auth, err := c.authMethod(key)
+	if err != nil {
+		logger.Fatalw("unable to load ssh key",
+			"action", "sshKeyParse",
+			"sshKey", key,
+			"error", err.Error(),
+		)
+	}
 	return auth
 }
@@ -0,0 +1,146 @@
+package gitoperations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// historyRefreshInterval limits how often a History fetches from its remote
+const historyRefreshInterval = 30 * time.Second
+
+// History is an in-memory (bare) clone of a single branch of an application repo
+// it orders commits so that tags built from them can be ranked by the commit graph rather than push time
+type History struct {
+	client  *Client
+	source  cfg.SourceRepo
+	mu      sync.Mutex
+	repo    *git.Repository
+	fetched time.Time
+	index   commitIndex
+}
+
+// commitIndex finds the position of a commit from its full or abbreviated hash
+type commitIndex struct {
+	positions map[string]int // full hash to how far it is from the branch head
+	hashes    []string       // sorted, to find abbreviated hashes
+}
+
+// NewHistory doesn't contact the remote, that happens on the first Refresh
+func (c *Client) NewHistory(source cfg.SourceRepo) *History {
+	return &History{client: c, source: source}
+}
+
+func (h *History) remoteRef() plumbing.ReferenceName {
+	return plumbing.ReferenceName(fmt.Sprintf("refs/remotes/origin/%s", h.source.Branch))
+}
+
+// Refresh clones the branch (or fetches it, at most every historyRefreshInterval) and re-reads its history
+func (h *History) Refresh() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.repo != nil && time.Since(h.fetched) < historyRefreshInterval {
+		return nil
+	}
+
+	auth, err := h.client.authMethod(h.source.Key)
+	if err != nil {
+		return err
+	}
+	if h.repo == nil {
+		logger.Debugw("cloning source repo history",
+			"url", h.source.URL,
+			"branch", h.source.Branch,
+		)
+		h.repo, err = git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
+			URL:           h.source.URL,
+			Auth:          auth,
+			SingleBranch:  true,
+			NoCheckout:    true,
+			Depth:         h.source.Depth,
+			ReferenceName: plumbing.NewBranchReferenceName(h.source.Branch),
+		})
+		if err != nil {
+			h.repo = nil
+			return fmt.Errorf("unable to clone %s: %w", h.source.URL, err)
+		}
+	} else {
+		err = h.repo.Fetch(&git.FetchOptions{
+			RefSpecs: []config.RefSpec{config.RefSpec(
+				fmt.Sprintf("+refs/heads/%s:%s", h.source.Branch, h.remoteRef()),
+			)},
+			Auth:  auth,
+			Depth: h.source.Depth,
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return fmt.Errorf("unable to fetch %s: %w", h.source.URL, err)
+		}
+	}
+	h.fetched = time.Now()
+
+	ref, err := h.repo.Reference(h.remoteRef(), true)
+	if err != nil {
+		return fmt.Errorf("branch %s of %s: %w", h.source.Branch, h.source.URL, err)
+	}
+	commits, err := h.repo.Log(&git.LogOptions{From: ref.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return err
+	}
+	index := commitIndex{positions: map[string]int{}}
+	err = commits.ForEach(func(commit *object.Commit) error {
+		hash := commit.Hash.String()
+		index.positions[hash] = len(index.hashes)
+		index.hashes = append(index.hashes, hash)
+		return nil
+	})
+	if err != nil && !(h.source.Depth > 0 && errors.Is(err, plumbing.ErrObjectNotFound)) {
+		// a shallow history ends with the parents which weren't cloned
+		return err
+	}
+	sort.Strings(index.hashes)
+	h.index = index
+	return nil
+}
+
+// Position returns how far a commit (full or abbreviated hash) is from the branch head, 0 being the head
+// false when the commit isn't in the branch history, or an abbreviated hash matches several commits
+func (h *History) Position(sha string) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	position, err := h.index.position(strings.ToLower(sha))
+	if err != nil {
+		logger.Warnw("unable to find commit in source repo history",
+			"url", h.source.URL,
+			"branch", h.source.Branch,
+			"error", err,
+		)
+		return 0, false
+	}
+	return position, position >= 0
+}
+
+// position is -1 when no commit matches sha
+func (c commitIndex) position(sha string) (int, error) {
+	if position, ok := c.positions[sha]; ok {
+		return position, nil
+	}
+	i := sort.SearchStrings(c.hashes, sha)
+	if i == len(c.hashes) || !strings.HasPrefix(c.hashes[i], sha) {
+		return -1, nil
+	}
+	if i+1 < len(c.hashes) && strings.HasPrefix(c.hashes[i+1], sha) {
+		return -1, fmt.Errorf("abbreviated commit %s is ambiguous", sha)
+	}
+	return c.positions[c.hashes[i]], nil
+}
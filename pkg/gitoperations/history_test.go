@@ -0,0 +1,41 @@
+package gitoperations
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCommitIndexPosition(t *testing.T) {
+	// newest first, the first two share the abbreviation "1a2b3c4"
+	commits := []string{
+		"1a2b3c4d00000000000000000000000000000000",
+		"1a2b3c4e00000000000000000000000000000000",
+		"9f8e7d6c00000000000000000000000000000000",
+	}
+	index := commitIndex{positions: map[string]int{}}
+	for i, hash := range commits {
+		index.positions[hash] = i
+		index.hashes = append(index.hashes, hash)
+	}
+	sort.Strings(index.hashes)
+
+	positionTests := []struct {
+		sha       string
+		position  int
+		ambiguous bool
+	}{
+		{"9f8e7d6c00000000000000000000000000000000", 2, false},
+		{"9f8e7d6", 2, false},
+		{"1a2b3c4e", 1, false},
+		{"1a2b3c4", -1, true},
+		{"0000000", -1, false},
+		{"ffffffff", -1, false},
+	}
+	for _, test := range positionTests {
+		position, err := index.position(test.sha)
+		if position != test.position || (err != nil) != test.ambiguous {
+			t.Errorf("position(%s), got: (%d, %v) but expected: (%d, ambiguous: %v)",
+				test.sha, position, err, test.position, test.ambiguous)
+		}
+	}
+}
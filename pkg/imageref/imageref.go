@@ -0,0 +1,104 @@
+// Package imageref parses docker image references, EG: "localhost:5000/team/app:1.0@sha256:abcd.."
+// they're validated by go-containerregistry (pkg/name), but not normalised (no "index.docker.io" or "library/")
+// so that a reference found in git can be written back exactly as it was
+package imageref
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Reference is a parsed image reference, every part but Path is optional
+type Reference struct {
+	Host   string // registry host including any port, EG: "localhost:5000"
+	Path   string // repository path, may be nested, EG: "acmecorp/team/app"
+	Tag    string // EG: "develop-1"
+	Digest string // EG: "sha256:abcd.."
+}
+
+// Parse parses an image reference
+// the first component is only a host when it contains a "." or ":" and more components follow (see SplitHost)
+func Parse(s string) (Reference, error) {
+	var ref Reference
+	base := s
+	if i := strings.Index(s, "@"); i >= 0 {
+		digest, err := name.NewDigest(s, name.WithDefaultRegistry(""))
+		if err != nil {
+			return Reference{}, err
+		}
+		base, ref.Digest = s[:i], digest.DigestStr()
+	}
+	if strings.HasSuffix(base, ":") {
+		return Reference{}, fmt.Errorf("empty tag in %q", s)
+	}
+	tag, err := name.NewTag(base, name.WithDefaultRegistry(""), name.WithDefaultTag(""))
+	if err != nil {
+		return Reference{}, err
+	}
+	ref.Tag = tag.TagStr()
+	ref.Host, ref.Path = SplitHost(strings.TrimSuffix(base, ":"+ref.Tag))
+	return ref, nil
+}
+
+// SplitHost splits an image name (or a registry, EG: "gcr.io/myorg") into the registry host and the path
+// like pkg/name the first component is a host when it contains a "." or ":", so "localhost/app" has no host
+// a lone host (EG: "112233445566.dkr.ecr.eu-west-2.amazonaws.com") is returned as the host
+func SplitHost(name string) (host, path string) {
+	first, rest, _ := strings.Cut(name, "/")
+	if !strings.ContainsAny(first, ".:") {
+		return "", name
+	}
+	return first, rest
+}
+
+// Name is the image without tag or digest, EG: "localhost:5000/team/app"
+func (r Reference) Name() string {
+	if r.Host == "" {
+		return r.Path
+	}
+	return r.Host + "/" + r.Path
+}
+
+// Hostname is the host without any port
+func (r Reference) Hostname() string {
+	host, _, _ := strings.Cut(r.Host, ":")
+	return host
+}
+
+// Port is the port of the host, if any
+func (r Reference) Port() string {
+	_, port, _ := strings.Cut(r.Host, ":")
+	return port
+}
+
+// String formats the reference as it was parsed
+func (r Reference) String() string {
+	return Format(r.Name(), r.Tag, r.Digest)
+}
+
+// Format joins an image name, tag and digest (either may be empty)
+// EG: ("reg/app", "develop-1", "sha256:abcd") == "reg/app:develop-1@sha256:abcd"
+func Format(name, tag, digest string) string {
+	result := name
+	if tag != "" {
+		result += ":" + tag
+	}
+	if digest != "" {
+		result += "@" + digest
+	}
+	return result
+}
+
+// Within reports if an image name belongs to a registry (or repository prefix), comparing whole components
+// EG: "gcr.io/myorg/app" is within "gcr.io/myorg" but "gcr.io/myorg-two/app" is not
+func Within(name, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// Trim removes the quotes and punctuation surrounding an image found in yaml/json, EG: `"reg/app:1.0",`
+func Trim(s string) string {
+	return strings.Trim(s, "\"',")
+}
@@ -0,0 +1,102 @@
+package imageref
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	parseTests := []struct {
+		input    string
+		expected Reference
+	}{
+		{"reg.io/app:develop-1", Reference{Host: "reg.io", Path: "app", Tag: "develop-1"}},
+		{"reg.io/app:develop-1@" + digest, Reference{Host: "reg.io", Path: "app", Tag: "develop-1", Digest: digest}},
+		{"reg.io/app@" + digest, Reference{Host: "reg.io", Path: "app", Digest: digest}},
+		{"localhost:5000/app:1.0", Reference{Host: "localhost:5000", Path: "app", Tag: "1.0"}},
+		{"localhost:5000/app", Reference{Host: "localhost:5000", Path: "app"}},
+		{"localhost/app", Reference{Path: "localhost/app"}},
+		{"docker.io/library/nginx:1.25", Reference{Host: "docker.io", Path: "library/nginx", Tag: "1.25"}},
+		{"acme/app:1.0", Reference{Path: "acme/app", Tag: "1.0"}},
+		{"ubuntu", Reference{Path: "ubuntu"}},
+		{
+			"112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp/team/sub/app:develop-52af76b8",
+			Reference{Host: "112233445566.dkr.ecr.eu-west-2.amazonaws.com", Path: "acmecorp/team/sub/app", Tag: "develop-52af76b8"},
+		},
+		{"europe-docker.pkg.dev/acme-org/my-registry/app:1.2.3", Reference{Host: "europe-docker.pkg.dev", Path: "acme-org/my-registry/app", Tag: "1.2.3"}},
+	}
+	for _, test := range parseTests {
+		ref, err := Parse(test.input)
+		if err != nil || ref != test.expected {
+			t.Errorf("Parse(%s), got: (%+v, %v) but expected: %+v", test.input, ref, err, test.expected)
+		}
+		if ref.String() != test.input {
+			t.Errorf("Parse(%s).String(), got: '%s'", test.input, ref.String())
+		}
+	}
+
+	for _, invalid := range []string{
+		"",
+		"reg.io/App:1.0",
+		"reg.io/app:",
+		"reg.io/app@sha256:abcd",
+		"reg.io/app@abcd",
+		"reg.io/app@" + digest + "@" + digest,
+		"reg.io:port/app",
+		`"reg.io/app:1.0"`,
+	} {
+		if ref, err := Parse(invalid); err == nil {
+			t.Errorf("Parse(%s), expected an error but got: %+v", invalid, ref)
+		}
+	}
+}
+
+func TestHostnameAndPort(t *testing.T) {
+	ref, err := Parse("localhost:5000/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Hostname() != "localhost" || ref.Port() != "5000" {
+		t.Errorf("Parse(localhost:5000/app:1.0), got: ('%s', '%s')", ref.Hostname(), ref.Port())
+	}
+}
+
+func TestSplitHost(t *testing.T) {
+	splitTests := []struct {
+		input string
+		host  string
+		path  string
+	}{
+		{"gcr.io/myorg", "gcr.io", "myorg"},
+		{"112233445566.dkr.ecr.eu-west-2.amazonaws.com", "112233445566.dkr.ecr.eu-west-2.amazonaws.com", ""},
+		{"localhost:5000", "localhost:5000", ""},
+		{"localhost", "", "localhost"},
+		{"acme/app", "", "acme/app"},
+	}
+	for _, test := range splitTests {
+		host, path := SplitHost(test.input)
+		if host != test.host || path != test.path {
+			t.Errorf("SplitHost(%s), got: ('%s', '%s') but expected: ('%s', '%s')", test.input, host, path, test.host, test.path)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	withinTests := []struct {
+		name     string
+		prefix   string
+		expected bool
+	}{
+		{"gcr.io/myorg/app", "gcr.io/myorg", true},
+		{"gcr.io/myorg/app", "gcr.io/myorg/", true},
+		{"gcr.io/myorg", "gcr.io/myorg", true},
+		{"gcr.io/myorg-two/app", "gcr.io/myorg", false},
+		{"localhost:5000/app", "localhost:50", false},
+	}
+	for _, test := range withinTests {
+		if got := Within(test.name, test.prefix); got != test.expected {
+			t.Errorf("Within(%s, %s), got: %v but expected: %v", test.name, test.prefix, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digtux/laminar/pkg/imageref"
+	"github.com/tidwall/buntdb"
+)
+
+const keyPrefix = "Pin:"
+
+// Pin freezes an image at its current tag (EG: during an incident) until removed or expired
+type Pin struct {
+	Image   string     `json:"image"`
+	Reason  string     `json:"reason,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Store keeps pins in buntDB next to the tag cache, so they survive restarts with a file-backed --cache
+type Store struct {
+	db *buntdb.DB
+}
+
+func New(db *buntdb.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// ErrInvalidImage is returned for an image which can't be pinned
+var ErrInvalidImage = errors.New("invalid image")
+
+// imageName validates the image of a pin, an image is pinned at whatever tag is in git so a tag or digest is refused
+func imageName(image string) (string, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return "", fmt.Errorf("%w: an image is required", ErrInvalidImage)
+	}
+	ref, err := imageref.Parse(image)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	if ref.Tag != "" || ref.Digest != "" {
+		return "", fmt.Errorf("%w: expected an image without a tag or digest, EG: %q", ErrInvalidImage, ref.Name())
+	}
+	return ref.Name(), nil
+}
+
+// Add pins an image, a zero ttl means the pin never expires
+// pinning an already pinned image replaces the previous pin
+func (s *Store) Add(image, reason string, ttl time.Duration) (Pin, error) {
+	image, err := imageName(image)
+	if err != nil {
+		return Pin{}, err
+	}
+	if ttl < 0 {
+		return Pin{}, fmt.Errorf("pin duration must not be negative, got %s", ttl)
+	}
+
+	p := Pin{
+		Image:   image,
+		Reason:  reason,
+		Created: time.Now(),
+	}
+	opts := &buntdb.SetOptions{}
+	if ttl > 0 {
+		expires := p.Created.Add(ttl)
+		p.Expires = &expires
+		opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+	}
+
+	byteArray, err := json.Marshal(p)
+	if err != nil {
+		return Pin{}, err
+	}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(keyPrefix+image, string(byteArray), opts)
+		return err
+	})
+	return p, err
+}
+
+// Get returns the pin for an image (if it is pinned)
+func (s *Store) Get(image string) (p Pin, pinned bool, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(keyPrefix + image)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pinned = true
+		return json.Unmarshal([]byte(val), &p)
+	})
+	return p, pinned, err
+}
+
+// List returns all pins which haven't expired
+func (s *Store) List() (pins []Pin, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		var decodeErr error
+		err := tx.AscendKeys(keyPrefix+"*", func(key, val string) bool {
+			var p Pin
+			if decodeErr = json.Unmarshal([]byte(val), &p); decodeErr != nil {
+				return false
+			}
+			pins = append(pins, p)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	return pins, err
+}
+
+// Remove unpins an image, returning false if it wasn't pinned
+func (s *Store) Remove(image string) (removed bool, err error) {
+	if image, err = imageName(image); err != nil {
+		return false, err
+	}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(keyPrefix + image)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,65 @@
+package pin
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestStore(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "cache.db")
+	db, err := buntdb.Open(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(db)
+
+	if _, err := s.Add("reg/acme/api", "incident 123", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("reg/acme/web", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"", "reg/acme/api:1.0.0", "reg/acme/api@sha256:" + strings.Repeat("a", 64), "reg/acme/API"} {
+		if _, err := s.Add(image, "", 0); !errors.Is(err, ErrInvalidImage) {
+			t.Errorf("Add(%s), got: %v but expected: %v", image, err, ErrInvalidImage)
+		}
+	}
+	if _, err := s.Remove("reg/acme/api:1.0.0"); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Remove(reg/acme/api:1.0.0), got: %v but expected: %v", err, ErrInvalidImage)
+	}
+
+	p, pinned, err := s.Get("reg/acme/api")
+	if err != nil || !pinned || p.Reason != "incident 123" || p.Expires != nil {
+		t.Errorf("Get(reg/acme/api), got: (%+v, %v, %v)", p, pinned, err)
+	}
+	if _, pinned, _ := s.Get("reg/acme/other"); pinned {
+		t.Error("expected reg/acme/other not to be pinned")
+	}
+
+	removed, err := s.Remove("reg/acme/api")
+	if err != nil || !removed {
+		t.Errorf("Remove(reg/acme/api), got: (%v, %v)", removed, err)
+	}
+	if removed, _ := s.Remove("reg/acme/api"); removed {
+		t.Error("expected a second Remove to report nothing removed")
+	}
+
+	// pins must survive a restart with a file-backed cache
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = buntdb.Open(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	pins, err := New(db).List()
+	if err != nil || len(pins) != 1 || pins[0].Image != "reg/acme/web" || pins[0].Expires == nil {
+		t.Errorf("List() after reopening, got: (%+v, %v)", pins, err)
+	}
+}
@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+// BlackList holds the parsed blacklist rules of an update policy
+type BlackList struct {
+	rules []blackListRule
+}
+
+type blackListRule struct {
+	entry   cfg.BlackList
+	image   TagPolicy
+	tag     TagPolicy
+	pattern TagPolicy
+}
+
+// NewBlackList parses blacklist entries, only "glob" and "regex" values are supported
+func NewBlackList(entries []cfg.BlackList) (*BlackList, error) {
+	b := &BlackList{}
+	for _, entry := range entries {
+		if entry.Image == "" && entry.Tag == "" && entry.Pattern == "" {
+			return nil, fmt.Errorf("blacklist entry is empty, set at least one of: image, tag, pattern")
+		}
+		rule := blackListRule{entry: entry}
+		var err error
+		if rule.image, err = parseMatcher(entry.Image); err != nil {
+			return nil, fmt.Errorf("blacklist image: %w", err)
+		}
+		if rule.tag, err = parseMatcher(entry.Tag); err != nil {
+			return nil, fmt.Errorf("blacklist tag: %w", err)
+		}
+		if rule.pattern, err = parseMatcher(entry.Pattern); err != nil {
+			return nil, fmt.Errorf("blacklist pattern: %w", err)
+		}
+		b.rules = append(b.rules, rule)
+	}
+	return b, nil
+}
+
+func parseMatcher(value string) (TagPolicy, error) {
+	if value == "" {
+		return nil, nil
+	}
+	p, err := Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.Type(); t != "glob" && t != "regex" {
+		return nil, fmt.Errorf("%q: only glob or regex can be used, got %q", value, t)
+	}
+	return p, nil
+}
+
+// Image returns the rule excluding an image entirely (a rule with only "image" set)
+func (b *BlackList) Image(image string) (string, bool) {
+	for _, rule := range b.rules {
+		if rule.tag == nil && rule.pattern == nil && rule.image.Match(image) {
+			return rule.String(), true
+		}
+	}
+	return "", false
+}
+
+// Veto rejects candidate tags matched by any rule with a "tag" or "pattern"
+func (b *BlackList) Veto(candidate registry.TagInfo) (string, bool) {
+	for _, rule := range b.rules {
+		if rule.tag == nil && rule.pattern == nil {
+			continue
+		}
+		if rule.image != nil && !rule.image.Match(candidate.Image) {
+			continue
+		}
+		if rule.tag != nil && !rule.tag.Match(candidate.Tag) {
+			continue
+		}
+		if rule.pattern != nil && !rule.pattern.Match(candidate.Image+":"+candidate.Tag) {
+			continue
+		}
+		return "blacklisted by " + rule.String(), true
+	}
+	return "", false
+}
+
+// String describes the rule as it was configured, EG: "image=glob:*/legacy-* tag=regex:.*-broken$"
+func (r blackListRule) String() string {
+	var fields []string
+	if r.entry.Image != "" {
+		fields = append(fields, "image="+r.entry.Image)
+	}
+	if r.entry.Tag != "" {
+		fields = append(fields, "tag="+r.entry.Tag)
+	}
+	if r.entry.Pattern != "" {
+		fields = append(fields, "pattern="+r.entry.Pattern)
+	}
+	return strings.Join(fields, " ")
+}
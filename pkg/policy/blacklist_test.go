@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func TestBlackList(t *testing.T) {
+	b, err := NewBlackList([]cfg.BlackList{
+		{Image: "glob:*/legacy-*"},
+		{Tag: "regex:.*-broken$"},
+		{Image: "glob:*/api", Tag: "glob:develop-bad*"},
+		{Pattern: "glob:reg/web:develop-0*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, blocked := b.Image("reg/acme/legacy-billing"); !blocked {
+		t.Error("expected reg/acme/legacy-billing to be blacklisted")
+	}
+	if _, blocked := b.Image("reg/acme/api"); blocked {
+		t.Error("expected reg/acme/api not to be blacklisted entirely")
+	}
+
+	vetoTests := []struct {
+		image  string
+		tag    string
+		vetoed bool
+	}{
+		{"reg/api", "develop-123-broken", true},
+		{"reg/api", "develop-bad1", true},
+		{"reg/web", "develop-bad1", false},
+		{"reg/web", "develop-0123", true},
+		{"reg/web", "develop-1123", false},
+	}
+	for _, test := range vetoTests {
+		reason, vetoed := b.Veto(registry.TagInfo{Image: test.image, Tag: test.tag})
+		if vetoed != test.vetoed {
+			t.Errorf("Veto(%s:%s), got: (%v, '%s') but expected: %v", test.image, test.tag, vetoed, reason, test.vetoed)
+		}
+	}
+
+	glob, err := Parse("glob:develop-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := WithVetoes(glob, b.Veto)
+	selected, found := p.Select("develop-1123", []registry.TagInfo{
+		{Image: "reg/api", Tag: "develop-125-broken"},
+		{Image: "reg/api", Tag: "develop-124"},
+		{Image: "reg/api", Tag: "develop-123"},
+	})
+	if !found || selected.Tag != "develop-124" {
+		t.Errorf("expected the blacklisted tag to be skipped, got: (%v, '%s')", found, selected.Tag)
+	}
+
+	for _, entry := range []cfg.BlackList{{}, {Tag: "semver:^1"}, {Image: "regex:("}} {
+		if _, err := NewBlackList([]cfg.BlackList{entry}); err == nil {
+			t.Errorf("expected blacklist entry %+v to be rejected", entry)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func init() {
+	Register("calver", newCalver)
+}
+
+const (
+	ConstraintSameYear  = "sameYear"
+	ConstraintSameMajor = "sameMajor"
+)
+
+// calverTokens are the format segments (see https://calver.org), longest first so "YYYY" isn't read as "YY"
+var calverTokens = []struct {
+	token string
+	re    string
+}{
+	{"YYYY", `\d{4}`},
+	{"MODIFIER", `[0-9A-Za-z]+(?:[.-][0-9A-Za-z]+)*`},
+	{"MAJOR", `\d+`},
+	{"MINOR", `\d+`},
+	{"MICRO", `\d+`},
+	{"YY", `[1-9]\d{0,2}|0`},
+	{"0Y", `\d{2,3}`},
+	{"MM", `1[0-2]|[1-9]`},
+	{"0M", `0[1-9]|1[0-2]`},
+	{"WW", `5[0-3]|[1-4]\d|[1-9]`},
+	{"0W", `5[0-3]|[1-4]\d|0[1-9]`},
+	{"DD", `3[01]|[12]\d|[1-9]`},
+	{"0D", `3[01]|[12]\d|0[1-9]`},
+}
+
+// calverPolicy promotes to the highest calendar version matching a format, EG: "calver:YYYY.0M.MICRO"
+// tokens are compared in the order they appear in the format, anything else in the format is literal text
+// with the constraint "sameYear" (or "sameMajor") only versions of the current tag's year (or MAJOR) are considered
+type calverPolicy struct {
+	value      string
+	re         *regexp.Regexp
+	tokens     []string
+	constraint string
+}
+
+// calverVersion is a tag parsed into the values of each token of the format
+type calverVersion []string
+
+func newCalver(value string, update cfg.Updates) (TagPolicy, error) {
+	p := &calverPolicy{value: value, constraint: update.Constraint}
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	seen := map[string]bool{}
+	for rest := value; rest != ""; {
+		matched := false
+		for _, t := range calverTokens {
+			if !strings.HasPrefix(rest, t.token) {
+				continue
+			}
+			if seen[t.token] {
+				return nil, fmt.Errorf("calver format %q: %s used twice", value, t.token)
+			}
+			seen[t.token] = true
+			p.tokens = append(p.tokens, t.token)
+			expr.WriteString("(" + t.re + ")")
+			rest = rest[len(t.token):]
+			matched = true
+			break
+		}
+		if !matched {
+			expr.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	expr.WriteString("$")
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("calver format %q contains no tokens, EG: YYYY.0M.MICRO", value)
+	}
+
+	switch p.constraint {
+	case "":
+	case ConstraintSameYear:
+		if !seen["YYYY"] && !seen["YY"] && !seen["0Y"] {
+			return nil, fmt.Errorf("constraint %q needs a year (YYYY, YY or 0Y) in the calver format", p.constraint)
+		}
+	case ConstraintSameMajor:
+		if !seen["MAJOR"] {
+			return nil, fmt.Errorf("constraint %q needs MAJOR in the calver format", p.constraint)
+		}
+	default:
+		return nil, fmt.Errorf("unknown calver constraint %q, expected %q or %q",
+			p.constraint, ConstraintSameYear, ConstraintSameMajor)
+	}
+
+	var err error
+	if p.re, err = regexp.Compile(expr.String()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *calverPolicy) Type() string { return "calver" }
+
+func (p *calverPolicy) Value() string { return p.value }
+
+// Match is true for any tag in the calver format
+func (p *calverPolicy) Match(tag string) bool {
+	_, ok := p.parse(tag)
+	return ok
+}
+
+func (p *calverPolicy) parse(tag string) (calverVersion, bool) {
+	m := p.re.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, false
+	}
+	return m[1:], true
+}
+
+// Select ignores "created" timestamps, the highest version (within the constraint) wins
+// but only if it is higher than currentTag
+func (p *calverPolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	current, ok := p.parse(currentTag)
+	if !ok {
+		return registry.TagInfo{}, false
+	}
+
+	var best registry.TagInfo
+	var bestVersion calverVersion
+	for _, candidate := range candidates {
+		v, ok := p.parse(candidate.Tag)
+		if !ok || !p.withinConstraint(current, v) {
+			continue
+		}
+		if bestVersion == nil || p.compare(v, bestVersion) > 0 {
+			best, bestVersion = candidate, v
+		}
+	}
+
+	if bestVersion == nil || p.compare(bestVersion, current) <= 0 {
+		return registry.TagInfo{}, false
+	}
+	return best, true
+}
+
+func (p *calverPolicy) withinConstraint(current, candidate calverVersion) bool {
+	switch p.constraint {
+	case ConstraintSameYear:
+		return p.year(current) == p.year(candidate)
+	case ConstraintSameMajor:
+		return p.number(current, "MAJOR") == p.number(candidate, "MAJOR")
+	}
+	return true
+}
+
+// year returns the full year of a version, short years ("YY" and "0Y") are years since 2000
+func (p *calverPolicy) year(v calverVersion) int {
+	for i, token := range p.tokens {
+		n, _ := strconv.Atoi(v[i])
+		switch token {
+		case "YYYY":
+			return n
+		case "YY", "0Y":
+			return 2000 + n
+		}
+	}
+	return 0
+}
+
+func (p *calverPolicy) number(v calverVersion, token string) int {
+	for i, t := range p.tokens {
+		if t == token {
+			n, _ := strconv.Atoi(v[i])
+			return n
+		}
+	}
+	return 0
+}
+
+func (p *calverPolicy) compare(a, b calverVersion) int {
+	for i, token := range p.tokens {
+		var c int
+		if token == "MODIFIER" {
+			c = compareNatural(a[i], b[i])
+		} else {
+			na, _ := strconv.Atoi(a[i])
+			nb, _ := strconv.Atoi(b[i])
+			c = compareInts(na, nb)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (p *calverPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	va, ok := p.parse(a.Tag)
+	if !ok {
+		return 0, fmt.Errorf("%q doesn't match calver format %q", a.Tag, p.value)
+	}
+	vb, ok := p.parse(b.Tag)
+	if !ok {
+		return 0, fmt.Errorf("%q doesn't match calver format %q", b.Tag, p.value)
+	}
+	return p.compare(va, vb), nil
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareNatural compares strings with runs of digits compared numerically, EG: "r2" < "r10"
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		ra, restA := leadingRun(a)
+		rb, restB := leadingRun(b)
+		na, errA := strconv.Atoi(ra)
+		nb, errB := strconv.Atoi(rb)
+		var c int
+		if errA == nil && errB == nil {
+			c = compareInts(na, nb)
+		} else {
+			c = strings.Compare(ra, rb)
+		}
+		if c != 0 {
+			return c
+		}
+		a, b = restA, restB
+	}
+	return compareInts(len(a), len(b))
+}
+
+// leadingRun splits off the leading run of digits, or of non digits
+func leadingRun(s string) (string, string) {
+	digits := unicode.IsDigit(rune(s[0]))
+	i := 1
+	for i < len(s) && unicode.IsDigit(rune(s[i])) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
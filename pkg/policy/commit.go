@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/gitoperations"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func init() {
+	Register("commit", newCommit)
+}
+
+// commitSHAPlaceholder marks where the commit hash is in a commit pattern, EG: "commit:develop-{sha}"
+const commitSHAPlaceholder = "{sha}"
+
+var (
+	historiesMu sync.Mutex
+	histories   = map[cfg.SourceRepo]*gitoperations.History{}
+)
+
+// historyFor shares one clone of each source repo branch between policies
+func historyFor(source cfg.SourceRepo) *gitoperations.History {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+	h, ok := histories[source]
+	if !ok {
+		h = gitoperations.New(cfg.Global{}).NewHistory(source)
+		histories[source] = h
+	}
+	return h
+}
+
+// commitPolicy promotes to the tag built from the most recent commit on a branch of the application repo
+// EG: "commit:develop-{sha}", rebuilding an old commit doesn't make its tag win as push time is ignored
+type commitPolicy struct {
+	value   string
+	re      *regexp.Regexp
+	source  cfg.SourceRepo
+	history *gitoperations.History
+}
+
+func newCommit(value string, update cfg.Updates) (TagPolicy, error) {
+	if strings.Count(value, commitSHAPlaceholder) != 1 {
+		return nil, fmt.Errorf("commit pattern %q must contain %s once, EG: develop-%s",
+			value, commitSHAPlaceholder, commitSHAPlaceholder)
+	}
+	if update.SourceRepo == nil || update.SourceRepo.URL == "" || update.SourceRepo.Branch == "" {
+		return nil, fmt.Errorf("commit pattern %q needs a sourceRepo with a url and branch", value)
+	}
+	if update.SourceRepo.Depth < 0 {
+		return nil, fmt.Errorf("sourceRepo depth must not be negative, got %d", update.SourceRepo.Depth)
+	}
+	prefix, suffix, _ := strings.Cut(value, commitSHAPlaceholder)
+	re, err := regexp.Compile("^" + regexp.QuoteMeta(prefix) + "([0-9a-fA-F]{7,40})" + regexp.QuoteMeta(suffix) + "$")
+	if err != nil {
+		return nil, err
+	}
+	// the history is only cloned once a tag is selected, so validating the config stays offline
+	return &commitPolicy{
+		value:   value,
+		re:      re,
+		source:  *update.SourceRepo,
+		history: historyFor(*update.SourceRepo),
+	}, nil
+}
+
+func (p *commitPolicy) Type() string { return "commit" }
+
+func (p *commitPolicy) Value() string { return p.value }
+
+func (p *commitPolicy) Match(tag string) bool {
+	return p.re.MatchString(tag)
+}
+
+func (p *commitPolicy) sha(tag string) (string, bool) {
+	m := p.re.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// position returns how far the commit of a tag is from the head of the branch (0 is the head)
+func (p *commitPolicy) position(tag string) (int, bool) {
+	sha, ok := p.sha(tag)
+	if !ok {
+		return 0, false
+	}
+	return p.history.Position(sha)
+}
+
+// Select picks the tag of the most recent commit in the branch history
+// tags of commits which aren't on the branch are ignored
+func (p *commitPolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	if err := p.history.Refresh(); err != nil {
+		logger.Warnw("unable to read source repo history",
+			"url", p.source.URL,
+			"branch", p.source.Branch,
+			"error", err,
+		)
+		return registry.TagInfo{}, false
+	}
+
+	var best registry.TagInfo
+	bestPosition, found := 0, false
+	for _, candidate := range candidates {
+		position, ok := p.position(candidate.Tag)
+		if ok && (!found || position < bestPosition) {
+			best, bestPosition, found = candidate, position, true
+		}
+	}
+	if !found {
+		return registry.TagInfo{}, false
+	}
+	if current, ok := p.position(currentTag); ok && current <= bestPosition {
+		return registry.TagInfo{}, false
+	}
+	return best, true
+}
+
+// Compare ranks tags by their commit's place in the branch history, newer commits are greater
+func (p *commitPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	pa, ok := p.position(a.Tag)
+	if !ok {
+		return 0, fmt.Errorf("commit of %q isn't in the history of %s", a.Tag, p.source.Branch)
+	}
+	pb, ok := p.position(b.Tag)
+	if !ok {
+		return 0, fmt.Errorf("commit of %q isn't in the history of %s", b.Tag, p.source.Branch)
+	}
+	return compareInts(pb, pa), nil
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newBareRepo returns the path of a local bare repo with the commits (oldest first) on branch "develop"
+func newBareRepo(t *testing.T, messages ...string) (string, []string) {
+	t.Helper()
+	workDir := t.TempDir()
+	work, err := git.PlainInit(workDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := work.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shas []string
+	when := time.Now().Add(-time.Hour)
+	for i, message := range messages {
+		hash, err := w.Commit(message, &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "dev", Email: "dev@acme", When: when.Add(time.Duration(i) * time.Minute)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shas = append(shas, hash.String())
+	}
+	head, err := work.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bareDir := t.TempDir()
+	bare, err := git.PlainInit(bareDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bare.CreateRemote(&gitconfig.RemoteConfig{Name: "work", URLs: []string{workDir}}); err != nil {
+		t.Fatal(err)
+	}
+	err = bare.Fetch(&git.FetchOptions{
+		RemoteName: "work",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(head.Name().String() + ":refs/heads/develop")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bareDir, shas
+}
+
+func TestCommitSelect(t *testing.T) {
+	bareDir, shas := newBareRepo(t, "one", "two", "three")
+	short := func(i int) string { return "develop-" + shas[i][:7] }
+
+	// pushed newest first: the oldest commit was rebuilt most recently
+	cached := []registry.TagInfo{
+		{Tag: short(0)},
+		{Tag: "develop-0000000"},
+		{Tag: short(2)},
+		{Tag: short(1)},
+		{Tag: "latest"},
+	}
+	p, err := New(cfg.Updates{
+		PatternString: "commit:develop-{sha}",
+		SourceRepo:    &cfg.SourceRepo{URL: bareDir, Branch: "develop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitTests := []struct {
+		current  string
+		found    bool
+		expected string
+	}{
+		{short(0), true, short(2)},
+		{short(1), true, short(2)},
+		{short(2), false, ""},
+		{"develop-abcdef0", true, short(2)},
+	}
+	for _, test := range commitTests {
+		selected, found := p.Select(test.current, cached)
+		if found != test.found || selected.Tag != test.expected {
+			t.Errorf("commit Select(%s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.current, found, selected.Tag, test.found, test.expected)
+		}
+	}
+
+	if c, err := p.Compare(registry.TagInfo{Tag: short(0)}, registry.TagInfo{Tag: short(2)}); err != nil || c != -1 {
+		t.Errorf("commit Compare(%s, %s), got: (%d, %v) but expected: -1", short(0), short(2), c, err)
+	}
+
+	for _, update := range []cfg.Updates{
+		{PatternString: "commit:develop-*", SourceRepo: &cfg.SourceRepo{URL: bareDir, Branch: "develop"}},
+		{PatternString: "commit:develop-{sha}"},
+		{PatternString: "commit:develop-{sha}", SourceRepo: &cfg.SourceRepo{URL: bareDir, Branch: "develop", Depth: -1}},
+	} {
+		if _, err := New(update); err == nil {
+			t.Errorf("New(%s), expected an error", update.PatternString)
+		}
+	}
+}
+
+func TestCommitSelectShallow(t *testing.T) {
+	bareDir, shas := newBareRepo(t, "one", "two", "three")
+	short := func(i int) string { return "develop-" + shas[i][:7] }
+	p, err := New(cfg.Updates{
+		PatternString: "commit:develop-{sha}",
+		SourceRepo:    &cfg.SourceRepo{URL: "file://" + bareDir, Branch: "develop", Depth: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first commit is beyond the depth, so only the 2 most recent are ranked
+	cached := []registry.TagInfo{{Tag: short(0)}, {Tag: short(1)}, {Tag: short(2)}}
+	if selected, found := p.Select(short(1), cached); !found || selected.Tag != short(2) {
+		t.Errorf("commit Select(%s, depth: 2), got: (%v, '%s') but expected: (true, '%s')", short(1), found, selected.Tag, short(2))
+	}
+	if c, err := p.Compare(registry.TagInfo{Tag: short(0)}, registry.TagInfo{Tag: short(2)}); err == nil {
+		t.Errorf("commit Compare(%s, %s, depth: 2), got: %d but expected an error", short(0), short(2), c)
+	}
+}
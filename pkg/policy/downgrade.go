@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+// noDowngradePolicy wraps a TagPolicy so that it refuses to select tags older than the current one
+type noDowngradePolicy struct {
+	TagPolicy
+}
+
+// WithoutDowngrades returns a TagPolicy which never selects a tag ranked below the current tag
+// if the current tag can't be ranked (EG: it disappeared from the registry) nothing is selected either
+func WithoutDowngrades(p TagPolicy) TagPolicy {
+	return &noDowngradePolicy{TagPolicy: p}
+}
+
+func (p *noDowngradePolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	selected, found := p.TagPolicy.Select(currentTag, candidates)
+	if !found || selected.Tag == currentTag {
+		return selected, found
+	}
+
+	current := registry.TagInfo{Image: selected.Image, Tag: currentTag}
+	for _, c := range candidates {
+		if c.Tag == currentTag {
+			current = c
+			break
+		}
+	}
+
+	comparison, err := p.Compare(selected, current)
+	if err != nil || comparison < 0 {
+		logger.Warnw("refusing to downgrade (set allowDowngrade to override)",
+			"image", selected.Image,
+			"currentTag", currentTag,
+			"currentCreated", current.Created,
+			"candidateTag", selected.Tag,
+			"candidateCreated", selected.Created,
+			"patternType", p.Type(),
+			"patternValue", p.Value(),
+			"error", err,
+		)
+		return registry.TagInfo{}, false
+	}
+	return selected, true
+}
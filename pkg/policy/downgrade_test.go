@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func TestWithoutDowngrades(t *testing.T) {
+	now := time.Now()
+	// a backfilled build was pushed after develop-3, so it sorts first in the "created" index
+	candidates := []registry.TagInfo{
+		{Image: "reg/app", Tag: "develop-1", Created: now},
+		{Image: "reg/app", Tag: "develop-3", Created: now.Add(-time.Hour)},
+		{Image: "reg/app", Tag: "1.2.0", Hash: "aaa"},
+		{Image: "reg/app", Tag: "stable", Hash: "aaa"},
+	}
+
+	downgradeTests := []struct {
+		pattern  string
+		current  string
+		found    bool
+		expected string
+	}{
+		// develop-1 was pushed more recently than develop-3, it is a newer candidate
+		{"glob:develop-*", "develop-3", true, "develop-1"},
+		// develop-0 isn't in the cache so it can't be ranked
+		{"glob:develop-*", "develop-0", false, ""},
+		{"regex:^develop-", "develop-0", false, ""},
+		{"semver:^1", "1.1.0", true, "1.2.0"},
+		// the stable channel was rolled back
+		{"follow:stable", "1.1.0", true, "1.2.0"},
+		{"follow:stable", "1.3.0", false, ""},
+	}
+	for _, test := range downgradeTests {
+		p, err := Parse(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, found := WithoutDowngrades(p).Select(test.current, candidates)
+		if found != test.found || selected.Tag != test.expected {
+			t.Errorf("WithoutDowngrades Select(%s, %s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.pattern, test.current, found, selected.Tag, test.found, test.expected)
+		}
+	}
+
+	// with an order set, regex ranks by the capture group rather than the created time
+	numerical, err := New(cfg.Updates{PatternString: `regex:^develop-(?P<n>\d+)$`, Order: OrderNumerical})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected, found := WithoutDowngrades(numerical).Select("develop-1", candidates); !found || selected.Tag != "develop-3" {
+		t.Errorf("expected develop-1 to be promoted to develop-3, got: (%v, '%s')", found, selected.Tag)
+	}
+	if _, err := numerical.Compare(candidates[0], candidates[1]); err != nil {
+		t.Error(err)
+	}
+}
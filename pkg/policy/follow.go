@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func init() {
+	Register("follow", newFollow)
+}
+
+// followPolicy follows a moving tag (EG: "follow:stable") but writes the immutable tag
+// which shares its digest (EG: "1.8.3"), keeping manifests reproducible while following a channel
+type followPolicy struct {
+	movingTag string
+}
+
+func newFollow(value string, _ cfg.Updates) (TagPolicy, error) {
+	return &followPolicy{movingTag: value}, nil
+}
+
+func (p *followPolicy) Type() string { return "follow" }
+
+func (p *followPolicy) Value() string { return p.movingTag }
+
+// Match is true for any tag, images without the moving tag in the registry are left alone by Select
+func (p *followPolicy) Match(_ string) bool {
+	return true
+}
+
+// Select finds the digest the moving tag currently points to and returns the most specific other tag
+// sharing it, preferring semver tags (1.8.3 over 1.8 over 1). The current tag is kept if it shares the digest
+func (p *followPolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	hash := ""
+	for _, c := range candidates {
+		if c.Tag == p.movingTag {
+			hash = c.Hash
+			break
+		}
+	}
+	if hash == "" {
+		return registry.TagInfo{}, false
+	}
+
+	var best registry.TagInfo
+	found := false
+	for _, c := range candidates {
+		if c.Hash != hash || c.Tag == p.movingTag {
+			continue
+		}
+		if c.Tag == currentTag {
+			return c, true
+		}
+		if !found || moreSpecific(c.Tag, best.Tag) {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+// Compare uses versions when both tags are semver, otherwise the "created" time
+func (p *followPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	va, errA := semver.NewVersion(a.Tag)
+	vb, errB := semver.NewVersion(b.Tag)
+	if errA == nil && errB == nil {
+		return va.Compare(vb), nil
+	}
+	return compareCreated(a, b)
+}
+
+// moreSpecific ranks immutable tags, semver first (most components, then highest), then the longest tag
+func moreSpecific(a, b string) bool {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	if (errA == nil) != (errB == nil) {
+		return errA == nil
+	}
+	if errA == nil {
+		if ca, cb := strings.Count(a, "."), strings.Count(b, "."); ca != cb {
+			return ca > cb
+		}
+		if !va.Equal(vb) {
+			return va.GreaterThan(vb)
+		}
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
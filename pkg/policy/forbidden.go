@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/imageref"
+	"github.com/digtux/laminar/pkg/registry"
+	"github.com/gobwas/glob"
+)
+
+// ForbiddenTags holds globs of tags which must never be written into git, EG: moving tags like "latest" or "nightly"
+// a nil *ForbiddenTags forbids nothing
+type ForbiddenTags struct {
+	global     []forbiddenGlob
+	registries []forbiddenRegistry
+}
+
+type forbiddenGlob struct {
+	value string
+	glob  glob.Glob
+}
+
+type forbiddenRegistry struct {
+	reg   string
+	globs []forbiddenGlob
+}
+
+// NewForbiddenTags parses the global forbidden tag globs and those of each docker registry
+// registry globs apply in addition to the global ones, for images of that registry only
+func NewForbiddenTags(global []string, registries []cfg.DockerRegistry) (*ForbiddenTags, error) {
+	f := &ForbiddenTags{}
+	var err error
+	if f.global, err = compileForbidden(global); err != nil {
+		return nil, err
+	}
+	for _, reg := range registries {
+		globs, err := compileForbidden(reg.ForbiddenTags)
+		if err != nil {
+			return nil, fmt.Errorf("registry %q: %w", reg.Reg, err)
+		}
+		f.registries = append(f.registries, forbiddenRegistry{reg: reg.Reg, globs: globs})
+	}
+	return f, nil
+}
+
+func compileForbidden(values []string) ([]forbiddenGlob, error) {
+	var result []forbiddenGlob
+	for _, value := range values {
+		g, err := glob.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("forbiddenTags %q: %w", value, err)
+		}
+		result = append(result, forbiddenGlob{value: value, glob: g})
+	}
+	return result, nil
+}
+
+// Tag returns the glob forbidding a tag of an image
+func (f *ForbiddenTags) Tag(image, tag string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	globs := f.global
+	if reg, ok := f.registryOf(image); ok {
+		globs = append(append([]forbiddenGlob{}, globs...), reg.globs...)
+	}
+	for _, g := range globs {
+		if g.glob.Match(tag) {
+			return g.value, true
+		}
+	}
+	return "", false
+}
+
+// registryOf returns the registry an image belongs to, the longest matching "reg" wins
+func (f *ForbiddenTags) registryOf(image string) (forbiddenRegistry, bool) {
+	var best forbiddenRegistry
+	found := false
+	for _, reg := range f.registries {
+		if imageref.Within(image, reg.reg) && (!found || len(reg.reg) > len(best.reg)) {
+			best, found = reg, true
+		}
+	}
+	return best, found
+}
+
+// Veto rejects candidates with a forbidden tag
+func (f *ForbiddenTags) Veto(candidate registry.TagInfo) (string, bool) {
+	if value, forbidden := f.Tag(candidate.Image, candidate.Tag); forbidden {
+		return fmt.Sprintf("forbidden tag (matches %q)", value), true
+	}
+	return "", false
+}
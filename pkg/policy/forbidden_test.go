@@ -0,0 +1,43 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/digtux/laminar/pkg/cfg"
+)
+
+func TestForbiddenTags(t *testing.T) {
+	f, err := NewForbiddenTags([]string{"latest"}, []cfg.DockerRegistry{
+		{Reg: "reg.acme.io", ForbiddenTags: []string{"nightly*"}},
+		{Reg: "reg.acme.io/edge", ForbiddenTags: []string{"edge"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forbiddenTests := []struct {
+		image     string
+		tag       string
+		forbidden bool
+	}{
+		{"other.io/app", "latest", true},
+		{"other.io/app", "nightly-1", false},
+		{"reg.acme.io/app", "nightly-1", true},
+		{"reg.acme.io/app", "edge", false},
+		{"reg.acme.io/edge/app", "edge", true},
+		{"reg.acme.io/edge/app", "latest", true},
+		{"reg.acme.io/app", "1.0.0", false},
+	}
+	for _, test := range forbiddenTests {
+		if _, forbidden := f.Tag(test.image, test.tag); forbidden != test.forbidden {
+			t.Errorf("ForbiddenTags.Tag(%s, %s), got: %v but expected: %v", test.image, test.tag, forbidden, test.forbidden)
+		}
+	}
+
+	var none *ForbiddenTags
+	if _, forbidden := none.Tag("reg/app", "latest"); forbidden {
+		t.Errorf("expected a nil ForbiddenTags to forbid nothing")
+	}
+	if _, err := NewForbiddenTags([]string{"[latest"}, nil); err == nil {
+		t.Errorf("expected an invalid glob to be rejected")
+	}
+}
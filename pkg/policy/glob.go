@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+	"github.com/gobwas/glob"
+)
+
+func init() {
+	Register("glob", newGlob)
+}
+
+// globPolicy promotes to the most recently pushed tag matching a glob, EG: "glob:develop-*"
+type globPolicy struct {
+	value string
+	glob  glob.Glob
+}
+
+func newGlob(value string, _ cfg.Updates) (TagPolicy, error) {
+	g, err := glob.Compile(value)
+	if err != nil {
+		return nil, err
+	}
+	return &globPolicy{value: value, glob: g}, nil
+}
+
+func (p *globPolicy) Type() string { return "glob" }
+
+func (p *globPolicy) Value() string { return p.value }
+
+func (p *globPolicy) Match(tag string) bool {
+	return p.glob.Match(tag)
+}
+
+func (p *globPolicy) Select(_ string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	return firstMatch(p, candidates)
+}
+
+func (p *globPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	return compareCreated(a, b)
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+// TagPolicy decides which docker tags an update policy may promote to
+type TagPolicy interface {
+	// Type is the prefix the policy was registered under, EG: "glob"
+	Type() string
+	// Value is the pattern without its prefix, EG: "develop-*"
+	Value() string
+	// Match reports if a tag (found in git) is governed by this policy
+	Match(tag string) bool
+	// Select picks the preferred tag from cached candidates (sorted newest first)
+	// returning false when there is nothing suitable to promote to
+	Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool)
+	// Compare ranks two tags the way the policy does: -1 if a is older/lower than b, 0 if equal, 1 if newer
+	// returning an error when it can't tell (EG: the "created" time of a tag isn't known)
+	Compare(a, b registry.TagInfo) (int, error)
+}
+
+// Factory builds a TagPolicy from the pattern value (the part after "<prefix>:")
+// the full Updates block is supplied so policies may read their own options
+type Factory func(value string, update cfg.Updates) (TagPolicy, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a TagPolicy available under a prefix, EG: "glob" for "glob:develop-*"
+// it is intended to be called from init() and panics if the prefix is already taken
+func Register(prefix string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, exists := factories[prefix]; exists {
+		panic(fmt.Sprintf("policy: Register called twice for prefix %q", prefix))
+	}
+	factories[prefix] = factory
+}
+
+// Types returns the registered prefixes (sorted)
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	var result []string
+	for prefix := range factories {
+		result = append(result, prefix)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// New returns the TagPolicy for an update's pattern string
+func New(update cfg.Updates) (TagPolicy, error) {
+	prefix, value, found := strings.Cut(update.PatternString, ":")
+	if !found || value == "" {
+		return nil, fmt.Errorf("pattern %q misconfigured, expected '<type>:<value>' (EG: 'glob:develop-*' or 'semver:~1.1')",
+			update.PatternString)
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[prefix]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("pattern %q has unsupported type %q, expected one of: %s",
+			update.PatternString, prefix, strings.Join(Types(), ", "))
+	}
+
+	tagPolicy, err := factory(value, update)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", update.PatternString, err)
+	}
+	return tagPolicy, nil
+}
+
+// Validate checks everything about an update policy which can be checked without a registry
+func Validate(update cfg.Updates) error {
+	if _, err := New(update); err != nil {
+		return err
+	}
+	if _, err := NewBlackList(update.BlackList); err != nil {
+		return err
+	}
+	if update.MinAge < 0 {
+		return fmt.Errorf("minAge must not be negative, got %s", update.MinAge)
+	}
+	for _, platform := range update.Platforms {
+		if err := registry.ValidatePlatform(platform); err != nil {
+			return err
+		}
+	}
+	if _, err := registry.LoadPublicKeys(update.SignatureKeys); err != nil {
+		return fmt.Errorf("signatureKeys: %w", err)
+	}
+	for key := range update.Labels {
+		if key == "" {
+			return fmt.Errorf("labels: empty label key")
+		}
+	}
+	return nil
+}
+
+// Parse is shorthand for New with just a pattern string and no other options
+func Parse(pattern string) (TagPolicy, error) {
+	return New(cfg.Updates{PatternString: pattern})
+}
+
+// compareCreated ranks tags by their "created" timestamp (as pushed to the registry)
+func compareCreated(a, b registry.TagInfo) (int, error) {
+	if a.Created.IsZero() || b.Created.IsZero() {
+		return 0, fmt.Errorf("created time unknown (tag %q or %q not in cache)", a.Tag, b.Tag)
+	}
+	switch {
+	case a.Created.Before(b.Created):
+		return -1, nil
+	case a.Created.After(b.Created):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// firstMatch returns the first candidate the policy matches, as the cache is sorted
+// by "created" (descending) this is the most recently pushed tag
+func firstMatch(p TagPolicy, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	for _, candidate := range candidates {
+		if p.Match(candidate.Tag) {
+			return candidate, true
+		}
+	}
+	return registry.TagInfo{}, false
+}
@@ -0,0 +1,232 @@
+package policy
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestNew(t *testing.T) {
+	parseTests := []struct {
+		update cfg.Updates
+		valid  bool
+	}{
+		{cfg.Updates{PatternString: "glob:develop-*"}, true},
+		{cfg.Updates{PatternString: "regex:^main-(?P<n>\\d+)-", Order: OrderNumerical}, true},
+		{cfg.Updates{PatternString: "semver:>=2.0.0 <3"}, true},
+		{cfg.Updates{PatternString: "develop-*"}, false},
+		{cfg.Updates{PatternString: "glob:"}, false},
+		{cfg.Updates{PatternString: "glob:develop-[*"}, false},
+		{cfg.Updates{PatternString: "regex:^main-(", Order: OrderNumerical}, false},
+		{cfg.Updates{PatternString: "regex:^main-", Order: "random"}, false},
+		{cfg.Updates{PatternString: "semver:not-a-constraint"}, false},
+		{cfg.Updates{PatternString: "nonsense:foo"}, false},
+	}
+	for _, test := range parseTests {
+		_, err := New(test.update)
+		if (err == nil) != test.valid {
+			t.Errorf("New(%s), got error: '%v' but expected valid: %v", test.update.PatternString, err, test.valid)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-exact", func(value string, _ cfg.Updates) (TagPolicy, error) {
+		return newGlob(value, cfg.Updates{})
+	})
+	t.Cleanup(func() {
+		factoriesMu.Lock()
+		delete(factories, "test-exact")
+		factoriesMu.Unlock()
+	})
+	if _, err := Parse("test-exact:develop-*"); err != nil {
+		t.Errorf("expected custom policy to be registered, got: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Register to panic for a duplicate prefix")
+		}
+	}()
+	Register("glob", newGlob)
+}
+
+func TestSemverSelect(t *testing.T) {
+	cached := []registry.TagInfo{
+		{Tag: "latest"},
+		{Tag: "v2.1.0"},
+		{Tag: "1.4.2"},
+		{Tag: "1.4.10"},
+		{Tag: "1.5.0-rc.1"},
+		{Tag: "1.3.9"},
+		{Tag: "develop-abc123"},
+		{Tag: "20240301"},
+		{Tag: "1.6"},
+	}
+	semverTests := []struct {
+		current    string
+		constraint string
+		found      bool
+		expected   string
+	}{
+		{"1.4.0", "~1.4.0", true, "1.4.10"},
+		{"1.4.0", "^1.2", true, "1.4.10"},
+		{"1.4.10", "^1.2", false, ""},
+		{"v1.0.0", ">=2.0.0 <3", true, "v2.1.0"},
+		{"1.4.0", "^1.5.0-0", true, "1.5.0-rc.1"},
+		{"2.1.0", "^2", false, ""},
+		{"develop-abc123", "^1", false, ""},
+		// neither a date nor a partial version is semver
+		{"1.4.10", ">=1 <2", false, ""},
+		{"20240301", "*", false, ""},
+	}
+	for _, test := range semverTests {
+		p, err := Parse("semver:" + test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, found := p.Select(test.current, cached)
+		if found != test.found || selected.Tag != test.expected {
+			t.Errorf("semver Select(%s, %s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.current, test.constraint, found, selected.Tag, test.found, test.expected)
+		}
+	}
+}
+
+func TestCalverSelect(t *testing.T) {
+	cached := []registry.TagInfo{
+		{Tag: "latest"},
+		{Tag: "2023.12.4"},
+		{Tag: "2024.3.1"},
+		{Tag: "2024.03.10"},
+		{Tag: "2024.03.9"},
+		{Tag: "2024.10.0"},
+		{Tag: "2025.01.0"},
+		{Tag: "24.10-r2"},
+		{Tag: "24.10-r10"},
+		{Tag: "24.9-r3"},
+	}
+	calverTests := []struct {
+		current    string
+		format     string
+		constraint string
+		found      bool
+		expected   string
+	}{
+		{"2024.03.1", "YYYY.0M.MICRO", "", true, "2025.01.0"},
+		{"2024.03.1", "YYYY.0M.MICRO", ConstraintSameYear, true, "2024.10.0"},
+		{"2023.12.4", "YYYY.0M.MICRO", ConstraintSameYear, false, ""},
+		{"2025.01.0", "YYYY.0M.MICRO", "", false, ""},
+		{"24.9-r3", "YY.MM-MODIFIER", "", true, "24.10-r10"},
+		{"24.9-r3", "YY.MM-rMICRO", "", true, "24.10-r10"},
+		{"develop-abc", "YYYY.0M.MICRO", "", false, ""},
+	}
+	for _, test := range calverTests {
+		p, err := New(cfg.Updates{PatternString: "calver:" + test.format, Constraint: test.constraint})
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, found := p.Select(test.current, cached)
+		if found != test.found || selected.Tag != test.expected {
+			t.Errorf("calver Select(%s, %s, %s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.current, test.format, test.constraint, found, selected.Tag, test.found, test.expected)
+		}
+	}
+
+	for _, update := range []cfg.Updates{
+		{PatternString: "calver:release"},
+		{PatternString: "calver:YYYY.YYYY"},
+		{PatternString: "calver:MAJOR.MICRO", Constraint: ConstraintSameYear},
+		{PatternString: "calver:YYYY.MICRO", Constraint: "sameDecade"},
+	} {
+		if _, err := New(update); err == nil {
+			t.Errorf("New(%s, %s), expected an error", update.PatternString, update.Constraint)
+		}
+	}
+}
+
+func TestSortTagsByRegexCapture(t *testing.T) {
+	// newest push first, as returned by the "created" index
+	cached := []registry.TagInfo{
+		{Tag: "main-9-backfill"},
+		{Tag: "main-10-aaa"},
+		{Tag: "main-2-bbb"},
+		{Tag: "develop-99-ccc"},
+	}
+	sortTests := []struct {
+		pattern  string
+		order    string
+		expected string
+	}{
+		{`^main-(?P<n>\d+)-`, OrderNumerical, "main-10-aaa"},
+		{`^main-(?P<n>\d+)-`, OrderAlphabetical, "main-9-backfill"},
+		{`^main-(\d+)-`, OrderNumerical, "main-10-aaa"},
+	}
+	for _, test := range sortTests {
+		sorted := SortTagsByRegexCapture(cached, regexp.MustCompile(test.pattern), test.order)
+		if sorted[0].Tag != test.expected {
+			t.Errorf("SortTagsByRegexCapture(%s, %s), got: '%s' but expected: '%s'",
+				test.pattern, test.order, sorted[0].Tag, test.expected)
+		}
+		if sorted[len(sorted)-1].Tag != "develop-99-ccc" {
+			t.Errorf("SortTagsByRegexCapture(%s, %s) expected non-matching tags last, got: '%s'",
+				test.pattern, test.order, sorted[len(sorted)-1].Tag)
+		}
+	}
+
+	p, err := New(cfg.Updates{PatternString: `regex:^main-(?P<n>\d+)-`, Order: OrderNumerical})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, found := p.Select("main-2-bbb", cached)
+	if !found || selected.Tag != "main-10-aaa" {
+		t.Errorf("expected regex Select to pick main-10-aaa, got: (%v, '%s')", found, selected.Tag)
+	}
+}
+
+func TestFollowSelect(t *testing.T) {
+	cached := []registry.TagInfo{
+		{Tag: "edge", Hash: "ccc"},
+		{Tag: "1.9.0-rc.1", Hash: "ccc"},
+		{Tag: "stable", Hash: "bbb"},
+		{Tag: "latest", Hash: "bbb"},
+		{Tag: "1", Hash: "bbb"},
+		{Tag: "1.8.3", Hash: "bbb"},
+		{Tag: "1.8", Hash: "bbb"},
+		{Tag: "build-1234", Hash: "bbb"},
+		{Tag: "1.8.2", Hash: "aaa"},
+	}
+	followTests := []struct {
+		current  string
+		moving   string
+		found    bool
+		expected string
+	}{
+		{"1.8.2", "stable", true, "1.8.3"},
+		{"stable", "stable", true, "1.8.3"},
+		{"1.8", "stable", true, "1.8"},
+		{"1.8.2", "edge", true, "1.9.0-rc.1"},
+		{"1.8.2", "nightly", false, ""},
+	}
+	for _, test := range followTests {
+		p, err := Parse("follow:" + test.moving)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, found := p.Select(test.current, cached)
+		if found != test.found || selected.Tag != test.expected {
+			t.Errorf("follow Select(%s, %s), got: (%v, '%s') but expected: (%v, '%s')",
+				test.current, test.moving, found, selected.Tag, test.found, test.expected)
+		}
+	}
+}
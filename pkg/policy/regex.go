@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+const (
+	OrderNumerical    = "numerical"
+	OrderAlphabetical = "alphabetical"
+)
+
+func init() {
+	Register("regex", newRegex)
+}
+
+// regexPolicy promotes to the most recently pushed tag matching a regex, EG: "regex:^develop-"
+// with an Order set the tag with the highest value in the first named capture group wins instead
+type regexPolicy struct {
+	value string
+	re    *regexp.Regexp
+	order string
+}
+
+func newRegex(value string, update cfg.Updates) (TagPolicy, error) {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, err
+	}
+	switch update.Order {
+	case "", OrderNumerical, OrderAlphabetical:
+	default:
+		return nil, fmt.Errorf("unknown order %q, expected %q or %q", update.Order, OrderNumerical, OrderAlphabetical)
+	}
+	return &regexPolicy{value: value, re: re, order: update.Order}, nil
+}
+
+func (p *regexPolicy) Type() string { return "regex" }
+
+func (p *regexPolicy) Value() string { return p.value }
+
+func (p *regexPolicy) Match(tag string) bool {
+	return p.re.MatchString(tag)
+}
+
+func (p *regexPolicy) Select(_ string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	if p.order != "" {
+		candidates = SortTagsByRegexCapture(candidates, p.re, p.order)
+	}
+	return firstMatch(p, candidates)
+}
+
+func (p *regexPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	if p.order == "" {
+		return compareCreated(a, b)
+	}
+	valueA, okA := extractRegexValue(p.re, a.Tag)
+	valueB, okB := extractRegexValue(p.re, b.Tag)
+	if !okA || !okB {
+		return 0, fmt.Errorf("unable to extract a value from tag %q or %q", a.Tag, b.Tag)
+	}
+	if p.order == OrderAlphabetical {
+		return strings.Compare(valueA, valueB), nil
+	}
+	numberA, errA := strconv.ParseUint(valueA, 10, 64)
+	numberB, errB := strconv.ParseUint(valueB, 10, 64)
+	if errA != nil || errB != nil {
+		return 0, fmt.Errorf("tag %q or %q doesn't contain a number", a.Tag, b.Tag)
+	}
+	switch {
+	case numberA < numberB:
+		return -1, nil
+	case numberA > numberB:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// SortTagsByRegexCapture re-orders a tag list (descending) by the value extracted from the regex
+// EG: `^main-(?P<n>\d+)-` with order "numerical" ranks main-10-abc above main-9-def
+// tags where nothing could be extracted are moved to the end, ties keep their "created" order
+func SortTagsByRegexCapture(tags []registry.TagInfo, re *regexp.Regexp, order string) []registry.TagInfo {
+	type rankedTag struct {
+		info   registry.TagInfo
+		value  string
+		number uint64
+		ok     bool
+	}
+	ranked := make([]rankedTag, 0, len(tags))
+	for _, t := range tags {
+		r := rankedTag{info: t}
+		r.value, r.ok = extractRegexValue(re, t.Tag)
+		if r.ok && order == OrderNumerical {
+			var err error
+			r.number, err = strconv.ParseUint(r.value, 10, 64)
+			r.ok = err == nil
+		}
+		ranked = append(ranked, r)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if order == OrderNumerical {
+			return a.number > b.number
+		}
+		return a.value > b.value
+	})
+
+	result := make([]registry.TagInfo, 0, len(ranked))
+	for _, r := range ranked {
+		result = append(result, r.info)
+	}
+	return result
+}
+
+// extractRegexValue returns the first named capture group of a match
+// (falling back to the first unnamed group, and then the whole match)
+func extractRegexValue(re *regexp.Regexp, input string) (string, bool) {
+	match := re.FindStringSubmatch(input)
+	if match == nil {
+		return "", false
+	}
+	for i, groupName := range re.SubexpNames() {
+		if groupName != "" {
+			return match[i], true
+		}
+	}
+	if len(match) > 1 {
+		return match[1], true
+	}
+	return match[0], true
+}
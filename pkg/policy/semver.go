@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func init() {
+	Register("semver", newSemver)
+}
+
+// semverPolicy promotes to the highest version satisfying a constraint, EG: "semver:^1.2"
+// tags with or without a "v" prefix are both understood and the original tag is preserved
+// pre-releases are only considered when the constraint itself includes a pre-release
+type semverPolicy struct {
+	value      string
+	constraint *semver.Constraints
+}
+
+func newSemver(value string, _ cfg.Updates) (TagPolicy, error) {
+	c, err := semver.NewConstraint(value)
+	if err != nil {
+		return nil, err
+	}
+	return &semverPolicy{value: value, constraint: c}, nil
+}
+
+// parseSemver only accepts complete versions (MAJOR.MINOR.PATCH), with an optional "v" prefix
+// semver.NewVersion also accepts "1.5" or "20240301" (a date, or build number, would outrank every release)
+func parseSemver(tag string) (*semver.Version, error) {
+	return semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+}
+
+func (p *semverPolicy) Type() string { return "semver" }
+
+func (p *semverPolicy) Value() string { return p.value }
+
+// Match is true for any semver tag, images on other tags aren't managed by this policy
+func (p *semverPolicy) Match(tag string) bool {
+	_, err := parseSemver(tag)
+	return err == nil
+}
+
+// Select ignores "created" timestamps, the highest satisfying version wins
+// but only if it is higher than currentTag
+func (p *semverPolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	currentVersion, err := parseSemver(currentTag)
+	if err != nil {
+		return registry.TagInfo{}, false
+	}
+
+	var best registry.TagInfo
+	var bestVersion *semver.Version
+	for _, candidate := range candidates {
+		v, err := parseSemver(candidate.Tag)
+		if err != nil || !p.constraint.Check(v) {
+			continue
+		}
+		if bestVersion == nil || v.GreaterThan(bestVersion) {
+			best, bestVersion = candidate, v
+		}
+	}
+
+	if bestVersion == nil || !bestVersion.GreaterThan(currentVersion) {
+		return registry.TagInfo{}, false
+	}
+	return best, true
+}
+
+func (p *semverPolicy) Compare(a, b registry.TagInfo) (int, error) {
+	va, err := parseSemver(a.Tag)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseSemver(b.Tag)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
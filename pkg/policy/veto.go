@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+// Veto is consulted for each tag a TagPolicy selects
+// returning a reason (for logging) when that tag may not be promoted
+type Veto func(candidate registry.TagInfo) (reason string, vetoed bool)
+
+// vetoPolicy wraps a TagPolicy so that vetoed tags are dropped and the next preferred tag is selected
+type vetoPolicy struct {
+	TagPolicy
+	vetoes []Veto
+}
+
+// WithVetoes returns a TagPolicy which will never select a tag rejected by one of the vetoes
+func WithVetoes(p TagPolicy, vetoes ...Veto) TagPolicy {
+	if len(vetoes) == 0 {
+		return p
+	}
+	return &vetoPolicy{TagPolicy: p, vetoes: vetoes}
+}
+
+func (p *vetoPolicy) Select(currentTag string, candidates []registry.TagInfo) (registry.TagInfo, bool) {
+	remaining := candidates
+	for {
+		selected, found := p.TagPolicy.Select(currentTag, remaining)
+		if !found || selected.Tag == currentTag {
+			return selected, found
+		}
+
+		reason, vetoed := p.veto(selected)
+		if !vetoed {
+			return selected, true
+		}
+		logger.Infow("skipping tag",
+			"image", selected.Image,
+			"tag", selected.Tag,
+			"currentTag", currentTag,
+			"reason", reason,
+		)
+		next := withoutTag(remaining, selected.Tag)
+		if len(next) == len(remaining) {
+			// the policy selected a tag which isn't a candidate, asking again would select it forever
+			logger.Warnw("policy selected a tag which isn't a candidate",
+				"image", selected.Image,
+				"tag", selected.Tag,
+				"currentTag", currentTag,
+			)
+			return registry.TagInfo{}, false
+		}
+		remaining = next
+	}
+}
+
+func (p *vetoPolicy) veto(candidate registry.TagInfo) (string, bool) {
+	for _, v := range p.vetoes {
+		if reason, vetoed := v(candidate); vetoed {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// MinAge vetoes tags created less than minAge ago (a soak time), the reason includes the remaining wait
+// tags without a created time are vetoed too
+func MinAge(minAge time.Duration) Veto {
+	return func(candidate registry.TagInfo) (string, bool) {
+		if minAge <= 0 {
+			return "", false
+		}
+		if candidate.Created.IsZero() {
+			// the age can't be known, so neither can the end of the soak time
+			return fmt.Sprintf("created time unknown (minAge %s)", minAge), true
+		}
+		age := time.Since(candidate.Created)
+		if age >= minAge {
+			return "", false
+		}
+		remaining := (minAge - age).Round(time.Second)
+		return fmt.Sprintf("too young (minAge %s), eligible in %s", minAge, remaining), true
+	}
+}
+
+// withoutTag returns a copy of the list with every entry for the tag removed
+func withoutTag(candidates []registry.TagInfo, tag string) []registry.TagInfo {
+	result := make([]registry.TagInfo, 0, len(candidates))
+	for _, c := range candidates {
+		if c.Tag != tag {
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/registry"
+)
+
+func TestMinAge(t *testing.T) {
+	now := time.Now()
+	candidates := []registry.TagInfo{
+		{Image: "reg/app", Tag: "develop-3", Created: now.Add(-5 * time.Minute)},
+		{Image: "reg/app", Tag: "develop-2", Created: now.Add(-45 * time.Minute)},
+		{Image: "reg/app", Tag: "develop-1", Created: now.Add(-2 * time.Hour)},
+	}
+
+	reason, vetoed := MinAge(30 * time.Minute)(candidates[0])
+	if !vetoed || !strings.Contains(reason, "eligible in 25m") {
+		t.Errorf("expected develop-3 to be vetoed with ~25m remaining, got: (%v, '%s')", vetoed, reason)
+	}
+	if _, vetoed := MinAge(0)(candidates[0]); vetoed {
+		t.Error("expected a zero minAge to never veto")
+	}
+	unknown := registry.TagInfo{Image: "reg/app", Tag: "develop-4"}
+	if reason, vetoed := MinAge(30 * time.Minute)(unknown); !vetoed || !strings.Contains(reason, "unknown") {
+		t.Errorf("expected develop-4 without a created time to be vetoed, got: (%v, '%s')", vetoed, reason)
+	}
+	if _, vetoed := MinAge(0)(unknown); vetoed {
+		t.Error("expected a zero minAge to never veto, even without a created time")
+	}
+
+	glob, err := Parse("glob:develop-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, found := WithVetoes(glob, MinAge(30*time.Minute)).Select("develop-1", candidates)
+	if !found || selected.Tag != "develop-2" {
+		t.Errorf("expected the youngest eligible tag develop-2, got: (%v, '%s')", found, selected.Tag)
+	}
+	if selected, _ := WithVetoes(glob, MinAge(3*time.Hour)).Select("develop-1", candidates); selected.Tag != "develop-1" {
+		t.Errorf("expected nothing newer to be eligible with a 3h minAge, got: '%s'", selected.Tag)
+	}
+}
+
+// foreignPolicy always selects a tag which isn't one of the candidates
+type foreignPolicy struct {
+	TagPolicy
+}
+
+func (foreignPolicy) Select(string, []registry.TagInfo) (registry.TagInfo, bool) {
+	return registry.TagInfo{Image: "reg/app", Tag: "elsewhere"}, true
+}
+
+func TestVetoPolicyForeignTag(t *testing.T) {
+	candidates := []registry.TagInfo{{Image: "reg/app", Tag: "develop-1"}}
+	veto := func(registry.TagInfo) (string, bool) { return "vetoed", true }
+	glob, err := Parse("glob:develop-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		_, found := WithVetoes(foreignPolicy{glob}, veto).Select("develop-1", candidates)
+		done <- found
+	}()
+	select {
+	case found := <-done:
+		if found {
+			t.Error("expected nothing to be selected when the policy selects a vetoed tag which isn't a candidate")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Select to give up on a tag which isn't a candidate, it's still looping")
+	}
+}
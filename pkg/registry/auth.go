@@ -0,0 +1,317 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/common"
+	"github.com/docker/cli/cli/config"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+)
+
+// keychainFunc adapts a function to an authn.Keychain
+type keychainFunc func(target authn.Resource) (authn.Authenticator, error)
+
+func (f keychainFunc) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return f(target)
+}
+
+// authKinds returns which kinds of credentials are set in an auth block
+func authKinds(auth cfg.RegistryAuth) []string {
+	var kinds []string
+	if auth.Username != "" || auth.Password != "" || auth.PasswordFile != "" {
+		kinds = append(kinds, "basic")
+	}
+	if auth.TokenFile != "" {
+		kinds = append(kinds, "tokenFile")
+	}
+	if auth.DockerConfig != "" {
+		kinds = append(kinds, "dockerConfig")
+	}
+	if auth.CredHelper != "" {
+		kinds = append(kinds, "credHelper")
+	}
+	if auth.AWSProfile != "" || auth.AWSRoleARN != "" {
+		kinds = append(kinds, "aws")
+	}
+	if auth.GCPKeyFile != "" {
+		kinds = append(kinds, "gcpKeyFile")
+	}
+	return kinds
+}
+
+// ValidateAuth ensures an auth block sets exactly one kind of credentials
+func ValidateAuth(auth *cfg.RegistryAuth) error {
+	if auth == nil {
+		return nil
+	}
+	kinds := authKinds(*auth)
+	switch {
+	case len(kinds) == 0:
+		return errors.New("auth is empty")
+	case len(kinds) > 1:
+		return fmt.Errorf("auth may only set one kind of credentials, got %v", kinds)
+	case kinds[0] == "basic" && (auth.Username == "" || (auth.Password == "") == (auth.PasswordFile == "")):
+		return errors.New("basic auth needs a username and either a password or passwordFile")
+	}
+	return nil
+}
+
+// typeAuthKinds are the only kinds of credentials some registry types can use, other types use any (see Keychain)
+var typeAuthKinds = map[string][]string{
+	"ecr": {"aws"},        // see AwsSession
+	"gar": {"gcpKeyFile"}, // the Artifact Registry API client
+}
+
+// ValidateRegistryAuth is ValidateAuth, also ensuring the registry's type can use the kind of credentials
+func ValidateRegistryAuth(registry cfg.DockerRegistry) error {
+	if err := ValidateAuth(registry.Auth); err != nil || registry.Auth == nil {
+		return err
+	}
+	allowed, ok := typeAuthKinds[Type(registry)]
+	if !ok {
+		return nil
+	}
+	kind := authKinds(*registry.Auth)[0]
+	for _, k := range allowed {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("a registry of type %q can't use %s auth, expected: %s", Type(registry), kind, strings.Join(allowed, ", "))
+}
+
+// Keychain returns the credentials of an auth block for go-containerregistry
+// without an auth block the ambient credentials are used (docker config.json, gcloud..)
+func Keychain(auth *cfg.RegistryAuth) (authn.Keychain, error) {
+	if auth == nil {
+		return authn.DefaultKeychain, nil
+	}
+	if err := ValidateAuth(auth); err != nil {
+		return nil, err
+	}
+
+	switch kind := authKinds(*auth)[0]; kind {
+	case "basic":
+		return keychainFunc(func(authn.Resource) (authn.Authenticator, error) {
+			password := auth.Password
+			if auth.PasswordFile != "" {
+				p, err := readSecretFile(auth.PasswordFile)
+				if err != nil {
+					return nil, err
+				}
+				password = p
+			}
+			return authn.FromConfig(authn.AuthConfig{Username: auth.Username, Password: password}), nil
+		}), nil
+	case "tokenFile":
+		return keychainFunc(func(authn.Resource) (authn.Authenticator, error) {
+			token, err := readSecretFile(auth.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			return authn.FromConfig(authn.AuthConfig{RegistryToken: token}), nil
+		}), nil
+	case "dockerConfig":
+		return keychainFunc(func(target authn.Resource) (authn.Authenticator, error) {
+			return dockerConfigAuthenticator(auth.DockerConfig, target)
+		}), nil
+	case "credHelper":
+		return authn.NewKeychainFromHelper(credHelper(auth.CredHelper)), nil
+	case "aws":
+		return keychainFunc(func(target authn.Resource) (authn.Authenticator, error) {
+			return ecrAuthenticator(auth, target.RegistryStr())
+		}), nil
+	case "gcpKeyFile":
+		key, err := os.ReadFile(common.GetFileAbsPath(auth.GCPKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		authenticator := google.NewJSONKeyAuthenticator(string(key))
+		return keychainFunc(func(authn.Resource) (authn.Authenticator, error) {
+			return authenticator, nil
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported auth %q", kind)
+	}
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(common.GetFileAbsPath(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// dockerConfigAuthenticator reads the credentials for a registry from a docker config.json
+// (including any credsStore/credHelpers it configures)
+func dockerConfigAuthenticator(path string, target authn.Resource) (authn.Authenticator, error) {
+	f, err := os.Open(common.GetFileAbsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cf, err := config.LoadFromReader(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		a, err := cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		if a.Username != "" || a.Password != "" || a.Auth != "" || a.IdentityToken != "" || a.RegistryToken != "" {
+			return authn.FromConfig(authn.AuthConfig{
+				Username:      a.Username,
+				Password:      a.Password,
+				Auth:          a.Auth,
+				IdentityToken: a.IdentityToken,
+				RegistryToken: a.RegistryToken,
+			}), nil
+		}
+	}
+	return authn.Anonymous, nil
+}
+
+// credHelper runs "docker-credential-<name> get", see:
+// https://docs.docker.com/engine/reference/commandline/login/#credential-helper-protocol
+type credHelper string
+
+func (h credHelper) Get(serverURL string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-" + string(h))
+	cmd.Args = append(cmd.Args, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s: %w: %s", h, err, strings.TrimSpace(stderr.String()))
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s: %w", h, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// AwsSession returns an AWS session using the profile and/or role of an auth block
+// without an auth block the ambient credentials are used
+func AwsSession(auth *cfg.RegistryAuth) (*session.Session, error) {
+	opts := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if auth != nil {
+		opts.Profile = auth.AWSProfile
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil && auth.AWSRoleARN != "" {
+		sess = sess.Copy(&aws.Config{Credentials: stscreds.NewCredentials(sess, auth.AWSRoleARN)})
+	}
+	return sess, nil
+}
+
+// ecrKeychain authenticates to ECR with AWS credentials, the ambient ones (env, ~/.aws, instance role..) when auth is nil
+func ecrKeychain(auth *cfg.RegistryAuth) authn.Keychain {
+	return keychainFunc(func(target authn.Resource) (authn.Authenticator, error) {
+		return ecrAuthenticator(auth, target.RegistryStr())
+	})
+}
+
+// ecrTokenRefresh is how long before it expires an ECR token is replaced (they're valid for 12 hours)
+const ecrTokenRefresh = 5 * time.Minute
+
+// ecrToken is an ECR registry token, cached per credentials and host
+type ecrToken struct {
+	config  authn.AuthConfig
+	expires time.Time
+}
+
+var (
+	ecrTokensMu sync.Mutex
+	ecrTokens   = map[string]ecrToken{}
+)
+
+// cachedEcrToken returns the token cached for key, calling fetch when there's none or it's about to expire
+func cachedEcrToken(key string, now time.Time, fetch func() (ecrToken, error)) (authn.AuthConfig, error) {
+	ecrTokensMu.Lock()
+	defer ecrTokensMu.Unlock()
+	if token, ok := ecrTokens[key]; ok && now.Before(token.expires.Add(-ecrTokenRefresh)) {
+		return token.config, nil
+	}
+	token, err := fetch()
+	if err != nil {
+		return authn.AuthConfig{}, err
+	}
+	ecrTokens[key] = token
+	return token.config, nil
+}
+
+// ecrAuthenticator exchanges AWS credentials for an ECR registry token (for reading manifests etc)
+// the token is reused until it's about to expire, rather than assuming a role etc for every request
+func ecrAuthenticator(auth *cfg.RegistryAuth, host string) (authn.Authenticator, error) {
+	key := host
+	if auth != nil {
+		key = strings.Join([]string{auth.AWSProfile, auth.AWSRoleARN, host}, "|")
+	}
+	config, err := cachedEcrToken(key, time.Now(), func() (ecrToken, error) {
+		return fetchEcrToken(auth, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return authn.FromConfig(config), nil
+}
+
+func fetchEcrToken(auth *cfg.RegistryAuth, host string) (ecrToken, error) {
+	sess, err := AwsSession(auth)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	svc := ecr.New(sess, aws.NewConfig().WithRegion(ecrRegion(host)))
+	out, err := svc.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return ecrToken{}, err
+	}
+	if len(out.AuthorizationData) == 0 {
+		return ecrToken{}, errors.New("ECR returned no authorization data")
+	}
+	data := out.AuthorizationData[0]
+	token, err := base64.StdEncoding.DecodeString(aws.StringValue(data.AuthorizationToken))
+	if err != nil {
+		return ecrToken{}, err
+	}
+	username, password, _ := strings.Cut(string(token), ":")
+	return ecrToken{
+		config:  authn.AuthConfig{Username: username, Password: password},
+		expires: aws.TimeValue(data.ExpiresAt),
+	}, nil
+}
+
+// ecrRegion returns the region of an ECR host, EG: "112233445566.dkr.ecr.eu-west-2.amazonaws.com" is "eu-west-2"
+func ecrRegion(host string) string {
+	host, _, _ = strings.Cut(host, ":")
+	parts := strings.Split(host, ".")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[3]
+}
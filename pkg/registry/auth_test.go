@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOciWorkerWithBasicAuth(t *testing.T) {
+	registryHandler := ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "laminar" || password != "s3cret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registryHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := u.Host + "/acme/api"
+
+	ref, err := name.ParseReference(image + ":1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := authn.FromConfig(authn.AuthConfig{Username: "laminar", Password: "s3cret"})
+	if err := remote.Write(ref, randomImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}), remote.WithAuth(basic)); err != nil {
+		t.Fatal(err)
+	}
+
+	authTests := []struct {
+		auth     *cfg.RegistryAuth
+		expected int
+	}{
+		{nil, 0},
+		{&cfg.RegistryAuth{Username: "laminar", Password: "wrong"}, 0},
+		{&cfg.RegistryAuth{Username: "laminar", PasswordFile: writeFile(t, "password", "s3cret\n")}, 1},
+	}
+	for _, test := range authTests {
+		db := cache.Open(":memory:")
+		New(db).Exec(cfg.DockerRegistry{Reg: u.Host + "/acme", Auth: test.auth}, []string{image})
+		if cached := New(db).CachedImagesToTagInfoListSpecificImage(image, "created"); len(cached) != test.expected {
+			t.Errorf("Exec(auth: %+v), got: %d tags but expected: %d", test.auth, len(cached), test.expected)
+		}
+	}
+}
+
+func TestKeychain(t *testing.T) {
+	// "auth" is base64 of "laminar:from-config"
+	dockerConfig := writeFile(t, "config.json", `{"auths":{"reg.acme.io":{"auth":"bGFtaW5hcjpmcm9tLWNvbmZpZw=="}}}`)
+	helperDir := t.TempDir()
+	helper := "#!/bin/sh\nread server\necho '{\"Username\":\"laminar\",\"Secret\":\"from-helper-'$server'\"}'\n"
+	if err := os.WriteFile(filepath.Join(helperDir, "docker-credential-acme"), []byte(helper), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", helperDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	keychainTests := []struct {
+		auth     cfg.RegistryAuth
+		expected authn.AuthConfig
+	}{
+		{cfg.RegistryAuth{Username: "laminar", Password: "inline"}, authn.AuthConfig{Username: "laminar", Password: "inline"}},
+		{cfg.RegistryAuth{TokenFile: writeFile(t, "token", "t0ken\n")}, authn.AuthConfig{RegistryToken: "t0ken"}},
+		{cfg.RegistryAuth{DockerConfig: dockerConfig}, authn.AuthConfig{Username: "laminar", Password: "from-config"}},
+		{cfg.RegistryAuth{CredHelper: "acme"}, authn.AuthConfig{Username: "laminar", Password: "from-helper-reg.acme.io"}},
+	}
+	repo, err := name.NewRepository("reg.acme.io/team/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range keychainTests {
+		test := test
+		keychain, err := Keychain(&test.auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Keychain(%+v).Resolve, got: %v", test.auth, err)
+		}
+		got, err := authenticator.Authorization()
+		if err != nil || got.Username != test.expected.Username ||
+			got.Password != test.expected.Password || got.RegistryToken != test.expected.RegistryToken {
+			t.Errorf("Keychain(%+v), got: (%+v, %v) but expected: %+v", test.auth, got, err, test.expected)
+		}
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	authTests := []struct {
+		auth  *cfg.RegistryAuth
+		valid bool
+	}{
+		{nil, true},
+		{&cfg.RegistryAuth{}, false},
+		{&cfg.RegistryAuth{Username: "laminar"}, false},
+		{&cfg.RegistryAuth{Username: "laminar", Password: "a", PasswordFile: "b"}, false},
+		{&cfg.RegistryAuth{TokenFile: "token", GCPKeyFile: "key.json"}, false},
+		{&cfg.RegistryAuth{AWSProfile: "prod", AWSRoleARN: "arn:aws:iam::112233445566:role/laminar"}, true},
+	}
+	for _, test := range authTests {
+		if err := ValidateAuth(test.auth); (err == nil) != test.valid {
+			t.Errorf("ValidateAuth(%+v), got: %v but expected valid: %v", test.auth, err, test.valid)
+		}
+	}
+}
+
+func TestValidateRegistryAuth(t *testing.T) {
+	ecr := "112233445566.dkr.ecr.eu-west-2.amazonaws.com"
+	authTests := []struct {
+		registry cfg.DockerRegistry
+		valid    bool
+	}{
+		{cfg.DockerRegistry{Reg: ecr}, true},
+		{cfg.DockerRegistry{Reg: ecr, Auth: &cfg.RegistryAuth{AWSProfile: "prod"}}, true},
+		{cfg.DockerRegistry{Reg: ecr, Auth: &cfg.RegistryAuth{TokenFile: "token"}}, false},
+		{cfg.DockerRegistry{Reg: "europe-docker.pkg.dev/acme", Auth: &cfg.RegistryAuth{GCPKeyFile: "key.json"}}, true},
+		{cfg.DockerRegistry{Reg: "europe-docker.pkg.dev/acme", Auth: &cfg.RegistryAuth{AWSRoleARN: "arn:aws:iam::112233445566:role/laminar"}}, false},
+		{cfg.DockerRegistry{Reg: "gcr.io/acme", Auth: &cfg.RegistryAuth{DockerConfig: "config.json"}}, true},
+		{cfg.DockerRegistry{Reg: "reg.acme.io", Auth: &cfg.RegistryAuth{AWSProfile: "prod"}}, true},
+		{cfg.DockerRegistry{Reg: "reg.acme.io", Auth: &cfg.RegistryAuth{}}, false},
+	}
+	for _, test := range authTests {
+		if err := ValidateRegistryAuth(test.registry); (err == nil) != test.valid {
+			t.Errorf("ValidateRegistryAuth(%s, %+v), got: %v but expected valid: %v", test.registry.Reg, test.registry.Auth, err, test.valid)
+		}
+	}
+}
+
+func TestCachedEcrToken(t *testing.T) {
+	ecrTokensMu.Lock()
+	ecrTokens = map[string]ecrToken{}
+	ecrTokensMu.Unlock()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fetched := 0
+	fetch := func() (ecrToken, error) {
+		fetched++
+		return ecrToken{
+			config:  authn.AuthConfig{Username: "AWS", Password: fmt.Sprintf("token-%d", fetched)},
+			expires: now.Add(12 * time.Hour),
+		}, nil
+	}
+	tokenTests := []struct {
+		key      string
+		now      time.Time
+		expected string
+	}{
+		{"prod|", now, "token-1"},
+		{"prod|", now.Add(time.Hour), "token-1"},
+		{"staging|", now.Add(time.Hour), "token-2"},
+		// about to expire
+		{"prod|", now.Add(12*time.Hour - time.Minute), "token-3"},
+	}
+	for _, test := range tokenTests {
+		got, err := cachedEcrToken(test.key, test.now, fetch)
+		if err != nil || got.Password != test.expected {
+			t.Errorf("cachedEcrToken(%s, %s), got: (%s, %v) but expected: %s", test.key, test.now, got.Password, err, test.expected)
+		}
+	}
+}
+
+func TestEcrRegion(t *testing.T) {
+	regionTests := map[string]string{
+		"112233445566.dkr.ecr.eu-west-2.amazonaws.com":     "eu-west-2",
+		"112233445566.dkr.ecr.us-east-1.amazonaws.com:443": "us-east-1",
+		"localhost:5000": "",
+	}
+	for host, expected := range regionTests {
+		if got := ecrRegion(host); got != expected {
+			t.Errorf("ecrRegion(%s), got: '%s' but expected: '%s'", host, got, expected)
+		}
+	}
+}
+
+func TestKeychainFor(t *testing.T) {
+	c := New(cache.Open(":memory:"), cfg.DockerRegistry{Reg: "reg.acme.io", Auth: &cfg.RegistryAuth{TokenFile: "token"}})
+	// keychainFunc is used for configured auth and for ECR, the other ambient credentials are gcrane.Keychain
+	keychainTests := []struct {
+		image    string
+		expected bool
+	}{
+		{"112233445566.dkr.ecr.eu-west-2.amazonaws.com/app", true},
+		{"gcr.io/acme/app", false},
+		{"reg.acme.io/app", true},
+	}
+	for _, test := range keychainTests {
+		if _, got := c.keychainFor(test.image).(keychainFunc); got != test.expected {
+			t.Errorf("keychainFor(%s), got keychainFunc: %v but expected: %v", test.image, got, test.expected)
+		}
+	}
+}
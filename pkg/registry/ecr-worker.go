@@ -1,15 +1,15 @@
 package registry
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/digtux/laminar/pkg/cfg"
-	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/imageref"
 	"github.com/tidwall/buntdb"
 )
 
@@ -28,94 +28,73 @@ import (
 // 	return strings.Compare(*c[i].ImageTag, *c[j].ImageTag) == -1
 // }
 
-func EcrGetAuth(registry cfg.DockerRegistry) (svc *ecr.ECR) {
-	mySession := session.Must(session.NewSession())
-	myRegion := strings.Split(registry.Reg, ".")[3]
-	svc = ecr.New(mySession, aws.NewConfig().WithRegion(myRegion))
-	return svc
+func init() {
+	RegisterWorker("ecr", newEcrWorker)
 }
 
-func EcrWorker(db *buntdb.DB, registry cfg.DockerRegistry, imageList []string) {
-	timeStart := time.Now()
-	totalTags := 0
-	auth := EcrGetAuth(registry)
-
-	// EG "112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp/app-name" == "acmecorp"
-	repoName := strings.Split(registry.Reg, "/")[1]
-
-	for _, img := range imageList {
-		logger.Debugw("EcrWorker",
-			"action", "scanning for image tags",
-			"image", img,
-		)
+// ecrWorker scans Amazon ECR, the repository is everything after the host
+// EG: "112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp/team/app-name" is "acmecorp/team/app-name"
+type ecrWorker struct {
+	svc *ecr.ECR
+}
 
-		// EG "112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp/app-name" == "app-name"
-		imgName := strings.Split(img, "/")[2]
+func newEcrWorker(registry cfg.DockerRegistry, _ *buntdb.DB) (RegistryWorker, error) {
+	svc, err := EcrGetAuth(registry)
+	if err != nil {
+		return nil, err
+	}
+	return &ecrWorker{svc: svc}, nil
+}
 
-		name := fmt.Sprintf("%s/%s", repoName, imgName)
-		newTagsCount := EcrDescribeImageToCache(auth, name, registry, db)
-		totalTags += newTagsCount
+func EcrGetAuth(registry cfg.DockerRegistry) (*ecr.ECR, error) {
+	// the profile/role of the registry's auth block, or the ambient AWS credentials
+	mySession, err := AwsSession(registry.Auth)
+	if err != nil {
+		return nil, err
 	}
+	host, _ := imageref.SplitHost(registry.Reg)
+	// every request (and page) is throttled and has its own deadline, see CallContext
+	httpClient := &http.Client{Transport: throttledTransport{base: http.DefaultTransport}}
+	return ecr.New(mySession, aws.NewConfig().WithRegion(ecrRegion(host)).WithHTTPClient(httpClient)), nil
+}
 
-	elapsed := time.Since(timeStart)
-	logger.Infow("Amazon ECR scan complete",
-		"elapsed", elapsed,
-		"registry", registry.Reg,
-		"totalImages", len(imageList),
-		"totalTags", totalTags,
-	)
+func (w *ecrWorker) ListTags(ctx context.Context, image string) ([]TagInfo, error) {
+	ref, err := imageref.Parse(image)
+	if err != nil {
+		return nil, err
+	}
+	return EcrDescribeImages(ctx, w.svc, ref)
 }
 
-func EcrDescribeImageToCache(
-	svc *ecr.ECR,
-	repositoryName string,
-	registry cfg.DockerRegistry,
-	db *buntdb.DB,
-) (total int) {
-	total = 0
+// EcrDescribeImages returns a TagInfo for every tag of an ECR image
+func EcrDescribeImages(ctx context.Context, svc *ecr.ECR, image imageref.Reference) ([]TagInfo, error) {
 	describeImageSettings := &ecr.DescribeImagesInput{
-		// EG: 112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp
-		RepositoryName: aws.String(repositoryName),
+		// the repository is everything after the host, EG: "acmecorp/team/app-name"
+		RepositoryName: aws.String(image.Path),
 	}
 
 	var imageDetails []*ecr.ImageDetail
 
 	// page through all ECR images and add them to the imageDetails slice
 	// https://github.com/terraform-providers/terraform-provider-aws/pull/8403/files/83d482992b6c42bea36d94f14b1da6616dc81ad1
-	err := svc.DescribeImagesPages(describeImageSettings, func(page *ecr.DescribeImagesOutput, lastPage bool) bool {
+	err := svc.DescribeImagesPagesWithContext(ctx, describeImageSettings, func(page *ecr.DescribeImagesOutput, lastPage bool) bool {
 		imageDetails = append(imageDetails, page.ImageDetails...)
 		return true
 	})
-
 	if err != nil {
-		logger.Fatalw("ECR DescribeImages failed",
-			"error", err,
-			"suggest", "maybe set $AWS_PROFILE?")
+		return nil, fmt.Errorf("ECR DescribeImages failed (maybe set $AWS_PROFILE?): %w", err)
 	}
 
-	// only the AWS sdk required the "prefix/myimage" part of the repositoryName, afterwards lets remove that
-	repositoryName = strings.Split(repositoryName, "/")[1]
-
+	var result []TagInfo
 	for _, hit := range imageDetails {
 		for _, tag := range hit.ImageTags {
-			total++
-			cleanerDigest := strings.Split(*hit.ImageDigest, ":")[1]
-			fullImageName := fmt.Sprintf("%s/%s", registry.Reg, repositoryName)
-
-			hitTagInfo := &TagInfo{
-				Image:   fullImageName,
-				Hash:    cleanerDigest,
+			result = append(result, TagInfo{
+				Image:   image.Name(),
+				Hash:    strings.TrimPrefix(*hit.ImageDigest, "sha256:"),
 				Tag:     *tag,
 				Created: *hit.ImagePushedAt,
-			}
-			TagInfoToCache(*hitTagInfo, db)
+			})
 		}
 	}
-	logger.Debugw("indexing image complete",
-		"registryUrl", registry.Reg,
-		"registryName", registry.Name,
-		"images", repositoryName,
-		"totalTags", len(imageDetails),
-	)
-	return total
+	return result, nil
 }
@@ -3,13 +3,19 @@ package registry
 import (
 	"context"
 	"fmt"
+	"net/url"
 
 	artifactregistry "cloud.google.com/go/artifactregistry/apiv1"
 	"cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
 	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/common"
+	"github.com/digtux/laminar/pkg/imageref"
 	"github.com/digtux/laminar/pkg/logger"
 	"github.com/tidwall/buntdb"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"strings"
 	"time"
@@ -20,9 +26,9 @@ import (
 // europe-docker.pkg.dev/your-project-id/your-registry-name
 func getRegistries(ctx context.Context, client artifactregistry.Client, registry cfg.DockerRegistry) ([]string, error) {
 	searchString := registry.Reg
-	domain := strings.Split(searchString, "/")[0]                 // would extract europe-docker.pkg.dev
-	location := strings.ReplaceAll(domain, "-docker.pkg.dev", "") // would extract "europe"
-	projectID := strings.Split(searchString, "/")[1]              // would extract "your-project-id"
+	domain, path := imageref.SplitHost(searchString)          // would extract europe-docker.pkg.dev
+	location := strings.TrimSuffix(domain, "-docker.pkg.dev") // would extract "europe"
+	projectID, _, _ := strings.Cut(path, "/")                 // would extract "your-project-id"
 	repoList, err := listGoogleArtifactRepositories(ctx, client, projectID, location)
 	if err != nil {
 		logger.Errorw("couldn't list repositories",
@@ -43,51 +49,84 @@ func getRegistries(ctx context.Context, client artifactregistry.Client, registry
 	return repoList, err
 }
 
-func GarWorker(db *buntdb.DB, registry cfg.DockerRegistry, imageList []string) {
-	logger.Warnw("Google Artifact Registry is BETA")
-	timeStart := time.Now()
-	totalTags := 0
-
-	ctx := context.Background()
-	client := newClient(ctx)
-	// defer client.Close but also check for errors
-	defer func(client *artifactregistry.Client) {
-		err := client.Close()
-		if err != nil {
-			logger.Fatalw("unexpected error closing google artifact registry client",
-				"error", err)
-		}
-	}(client)
+func init() {
+	RegisterWorker("gar", newGarWorker)
+}
+
+// garWorker scans Google Artifact Registry (<location>-docker.pkg.dev)
+type garWorker struct {
+	client   *artifactregistry.Client
+	registry cfg.DockerRegistry
+}
 
-	garRepos, err := getRegistries(ctx, *client, registry)
+func newGarWorker(registry cfg.DockerRegistry, _ *buntdb.DB) (RegistryWorker, error) {
+	logger.Warnw("Google Artifact Registry is BETA")
+	client, err := newClient(context.Background(), registry.Auth)
 	if err != nil {
-		logger.Fatalw("couldn't get registries",
-			"err", err,
-		)
+		return nil, err
 	}
+	return &garWorker{client: client, registry: registry}, nil
+}
 
+func (w *garWorker) ListTags(ctx context.Context, image string) ([]TagInfo, error) {
+	return garListPackageVersions(ctx, *w.client, image)
+}
+
+// ScanAll lists every image of every repository in the project (see fullScan)
+func (w *garWorker) ScanAll(ctx context.Context) ([]TagInfo, error) {
+	garRepos, err := getRegistries(ctx, *w.client, w.registry)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get registries: %w", err)
+	}
+	var result []TagInfo
 	for _, repo := range garRepos {
-		total := garDescribeAllRepositoryImagesToCache(ctx, *client, repo, db)
-		totalTags += total
+		tags, err := garDescribeAllRepositoryImages(ctx, *w.client, repo)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, tags...)
 	}
+	return result, nil
+}
 
-	elapsed := time.Since(timeStart)
-	logger.Infow("Google Artifact Registry scan complete",
-		"elapsed", elapsed,
-		"registry", registry.Reg,
-		"totalUniqueImages", len(imageList),
-		"totalTags", totalTags,
-	)
+func (w *garWorker) Close() error {
+	return w.client.Close()
 }
 
-func newClient(ctx context.Context) *artifactregistry.Client {
-	client, err := artifactregistry.NewClient(ctx)
+func newClient(ctx context.Context, auth *cfg.RegistryAuth) (*artifactregistry.Client, error) {
+	var opts []option.ClientOption
+	if auth != nil && auth.GCPKeyFile != "" {
+		opts = append(opts, option.WithCredentialsFile(common.GetFileAbsPath(auth.GCPKeyFile)))
+	}
+	client, err := artifactregistry.NewClient(ctx, opts...)
 	if err != nil {
-		logger.Fatalw("Couldn't auth.. did you run: 'gcloud auth application-default login' ?",
-			"error", err,
-		)
+		return nil, fmt.Errorf("couldn't auth.. did you run: 'gcloud auth application-default login' ?: %w", err)
+	}
+	return client, nil
+}
+
+// garPageSize is the number of results requested per API call
+const garPageSize = 500
+
+// garPages calls fetch for every page of a listing until it returns an empty page token
+// each page is a separate API call, so it's throttled and has its own deadline (see CallContext)
+func garPages(ctx context.Context, fetch func(ctx context.Context, pageToken string) (string, error)) error {
+	pageToken := ""
+	for {
+		if err := Throttle(ctx); err != nil {
+			return err
+		}
+		callCtx, cancel := CallContext(ctx)
+		next, err := fetch(callCtx, pageToken)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		pageToken = next
 	}
-	return client
 }
 
 func listGoogleArtifactRepositories(
@@ -99,101 +138,172 @@ func listGoogleArtifactRepositories(
 	error,
 ) {
 	var result []string
-	var err error
 
 	// parent formatting is important
 	// See: https://cloud.google.com/artifact-registry/docs/reference/rest/v1/projects.locations.repositories/list
 	parent := fmt.Sprintf("projects/%s/locations/%s", projectID, location)
-	it := client.ListRepositories(ctx, &artifactregistrypb.ListRepositoriesRequest{
-		Parent: parent,
+	err := garPages(ctx, func(ctx context.Context, pageToken string) (string, error) {
+		var page []*artifactregistrypb.Repository
+		next, err := iterator.NewPager(client.ListRepositories(ctx, &artifactregistrypb.ListRepositoriesRequest{
+			Parent: parent,
+		}), garPageSize, pageToken).NextPage(&page)
+		for _, resp := range page {
+			if resp.Format.String() == "DOCKER" {
+				logger.Debugw("Google artifact registry (format: DOCKER) found",
+					"name", resp.Name,
+					"format", resp.Format,
+					"description", resp.Description,
+				)
+				result = append(result, resp.Name)
+			}
+		}
+		return next, err
 	})
+	return result, err
+}
 
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return result, err
-		}
-		if resp.Format.String() == "DOCKER" {
-			logger.Debugw("Google artifact registry (format: DOCKER) found",
-				"name", resp.Name,
-				"format", resp.Format,
-				"description", resp.Description,
-			)
-			result = append(result, resp.Name)
-		}
+// garPackageName returns the resource name of the package holding an image
+// EG: "europe-docker.pkg.dev/acme-org/my-registry/team/app" is the package "team/app" (url encoded)
+// of "projects/acme-org/locations/europe/repositories/my-registry"
+func garPackageName(image string) (string, error) {
+	ref, err := imageref.Parse(image)
+	if err != nil {
+		return "", err
 	}
-	return result, err
+	parts := strings.SplitN(ref.Path, "/", 3)
+	if len(parts) != 3 || !strings.HasSuffix(ref.Host, "-docker.pkg.dev") {
+		return "", fmt.Errorf("expected an image such as '<location>-docker.pkg.dev/<project>/<repository>/<image>', got %q", image)
+	}
+	location := strings.TrimSuffix(ref.Host, "-docker.pkg.dev")
+	return fmt.Sprintf("projects/%s/locations/%s/repositories/%s/packages/%s",
+		parts[0], location, parts[1], url.PathEscape(parts[2]),
+	), nil
 }
 
-// TODO: figure out how to scan an individual dockerImage
-func garDescribeAllRepositoryImagesToCache(
+// garListPackageVersions returns every tagged version of a single image
+// which is much quicker than listing every image of the repository (see fullScan)
+func garListPackageVersions(
 	ctx context.Context, client artifactregistry.Client,
-	repository string,
-	db *buntdb.DB,
-) (totalTags int) { // parent := repository,
-	// "projects/<projectID>/locations/<location>/repositories/<repoName>"
-	it := client.ListDockerImages(ctx, &artifactregistrypb.ListDockerImagesRequest{
-		Parent: repository,
+	image string,
+) ([]TagInfo, error) {
+	parent, err := garPackageName(image)
+	if err != nil {
+		return nil, err
+	}
+	var result []TagInfo
+	err = garPages(ctx, func(ctx context.Context, pageToken string) (string, error) {
+		var page []*artifactregistrypb.Version
+		next, err := iterator.NewPager(client.ListVersions(ctx, &artifactregistrypb.ListVersionsRequest{
+			Parent: parent,
+			View:   artifactregistrypb.VersionView_FULL, // includes the related tags
+		}), garPageSize, pageToken).NextPage(&page)
+		for _, version := range page {
+			result = append(result, convertGarVersionToTagInfo(image, version)...)
+		}
+		return next, err
 	})
+	return result, err
+}
 
-	countUniqueTags := 0
+func convertGarVersionToTagInfo(image string, version *artifactregistrypb.Version) []TagInfo {
+	// version names end with the digest, EG: ".../packages/app/versions/sha256:8a1aa5d3..."
+	digest := version.Name[strings.LastIndex(version.Name, "/")+1:]
+	hash := strings.TrimPrefix(digest, "sha256:")
 
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			logger.Panic(err)
-		}
-		// TODO: we assume there are tags on an image.
-		// this might be complicated for some folks might use raw sha256
-		for _, tag := range resp.Tags {
-			tagInfo := convertGarResponseToTagInfo(resp, tag)
-			TagInfoToCache(tagInfo, db)
-			countUniqueTags++
+	// the FULL view has the DockerImage as metadata, its BuildTime is what the full scan uses too
+	// (CreateTime is when the version was pushed to this repository, EG: a copy of an old image is "new")
+	var dockerImage artifactregistrypb.DockerImage
+	metadata, err := version.Metadata.MarshalJSON()
+	if err == nil {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(metadata, &dockerImage)
+	}
+	if err != nil {
+		logger.Warnw("unexpected Google Artifact Registry version metadata",
+			"version", version.Name,
+			"error", err,
+		)
+	}
+
+	var result []TagInfo
+	for _, tag := range version.RelatedTags {
+		// tag names end with the tag, EG: ".../packages/app/tags/1.2.3"
+		result = append(result, TagInfo{
+			Image:   image,
+			Hash:    hash,
+			Tag:     tag.Name[strings.LastIndex(tag.Name, "/")+1:],
+			Created: garBuildTime(dockerImage.BuildTime),
+		})
+	}
+	return result
+}
+
+// garBuildTime converts the .BuildTime of an image, it contains two numbers
+// - the unix epoch in second
+// - the nanosecond underneath that second
+// an unknown build time stays zero (rather than 1970)
+func garBuildTime(buildTime *timestamppb.Timestamp) time.Time {
+	if buildTime == nil {
+		return time.Time{}
+	}
+	return time.Unix(buildTime.GetSeconds(), int64(buildTime.GetNanos()))
+}
+
+// garDescribeAllRepositoryImages lists every image of a repository (see fullScan)
+func garDescribeAllRepositoryImages(
+	ctx context.Context, client artifactregistry.Client,
+	repository string,
+) ([]TagInfo, error) { // parent := repository,
+	// "projects/<projectID>/locations/<location>/repositories/<repoName>"
+	var result []TagInfo
+	err := garPages(ctx, func(ctx context.Context, pageToken string) (string, error) {
+		var page []*artifactregistrypb.DockerImage
+		next, err := iterator.NewPager(client.ListDockerImages(ctx, &artifactregistrypb.ListDockerImagesRequest{
+			Parent: repository,
+		}), garPageSize, pageToken).NextPage(&page)
+		for _, resp := range page {
+			// TODO: we assume there are tags on an image.
+			// this might be complicated for some folks might use raw sha256
+			for _, tag := range resp.Tags {
+				tagInfo, err := convertGarResponseToTagInfo(resp, tag)
+				if err != nil {
+					logger.Errorw("unexpected Google Artifact Registry image",
+						"uri", resp.Uri,
+						"error", err,
+					)
+					continue
+				}
+				result = append(result, tagInfo)
+			}
 		}
+		return next, err
+	})
+	if err != nil {
+		return result, err
 	}
 	logger.Infow("Google Artifact Registry scanned",
-		"countUniqueTags", countUniqueTags,
+		"repository", repository,
+		"countUniqueTags", len(result),
 	)
-	return countUniqueTags
+	return result, nil
 }
 
-func convertGarResponseToTagInfo(resp *artifactregistrypb.DockerImage, tag string) TagInfo {
+func convertGarResponseToTagInfo(resp *artifactregistrypb.DockerImage, tag string) (TagInfo, error) {
 	// formats DockerImage data
 	//
 	// Uri example: "europe-docker.pkg.dev/acme-org/my-registry/image-name@sha256:8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b
-	//
-	// imageName: "europe-docker.pkg.dev/acme-org/my-registry/image-name"
-	imageName := strings.Split(resp.Uri, "@")[0]
-	// splitHash: "8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b"
-	splitHash := strings.Split(resp.Uri, ":")
-	if len(splitHash) != 2 {
-		logger.Fatalw("Expected just a single ':'",
-			"value", resp.Uri,
-			"splitHash", splitHash,
-			"count", len(splitHash),
-		)
+	ref, err := imageref.Parse(resp.Uri)
+	if err != nil {
+		return TagInfo{}, err
+	}
+	if ref.Digest == "" {
+		return TagInfo{}, fmt.Errorf("expected a digest in %q", resp.Uri)
 	}
-	hash := splitHash[1]
 
-	// the .BuildTime contains two numbers
-	// - the unix epoch in second
-	// - the nanosecond underneath that second
-	// This converts them to a regular time.Time
-	created := time.Unix(
-		resp.BuildTime.GetSeconds(),
-		int64(resp.BuildTime.GetNanos()),
-	)
 	imageData := TagInfo{
-		Image:   imageName,
+		Image:   ref.Name(), // EG: "europe-docker.pkg.dev/acme-org/my-registry/image-name"
 		Tag:     tag,
-		Hash:    hash,
-		Created: created,
+		Hash:    strings.TrimPrefix(ref.Digest, "sha256:"), // EG: "8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b"
+		Created: garBuildTime(resp.BuildTime),
 	}
-	return imageData
+	return imageData, nil
 }
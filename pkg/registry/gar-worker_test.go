@@ -0,0 +1,141 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGarPackageName(t *testing.T) {
+	packageTests := []struct {
+		image    string
+		expected string
+		valid    bool
+	}{
+		{
+			"europe-docker.pkg.dev/acme-org/my-registry/app",
+			"projects/acme-org/locations/europe/repositories/my-registry/packages/app",
+			true,
+		},
+		{
+			"us-docker.pkg.dev/acme-org/my-registry/team/sub/app",
+			"projects/acme-org/locations/us/repositories/my-registry/packages/team%2Fsub%2Fapp",
+			true,
+		},
+		{"europe-docker.pkg.dev/acme-org/my-registry", "", false},
+		{"gcr.io/acme-org/my-registry/app", "", false},
+	}
+	for _, test := range packageTests {
+		name, err := garPackageName(test.image)
+		if name != test.expected || (err == nil) != test.valid {
+			t.Errorf("garPackageName(%s), got: ('%s', %v) but expected: '%s'", test.image, name, err, test.expected)
+		}
+	}
+}
+
+func TestConvertGarVersionToTagInfo(t *testing.T) {
+	image := "europe-docker.pkg.dev/acme-org/my-registry/app"
+	parent := "projects/acme-org/locations/europe/repositories/my-registry/packages/app"
+	hash := "8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b"
+	built := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	dockerImage := &artifactregistrypb.DockerImage{
+		Uri:       image + "@sha256:" + hash,
+		Tags:      []string{"1.2.3", "stable"},
+		BuildTime: timestamppb.New(built),
+	}
+	metadata, err := protojson.Marshal(dockerImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := &artifactregistrypb.Version{
+		Name: parent + "/versions/sha256:" + hash,
+		// pushed (EG: copied from another repository) long after it was built
+		CreateTime: timestamppb.New(built.Add(30 * 24 * time.Hour)),
+		RelatedTags: []*artifactregistrypb.Tag{
+			{Name: parent + "/tags/1.2.3"},
+			{Name: parent + "/tags/stable"},
+		},
+		Metadata: &structpb.Struct{},
+	}
+	if err := version.Metadata.UnmarshalJSON(metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	// both scans must agree, or the same image sorts differently depending on how it was found
+	var expected []TagInfo
+	for _, tag := range dockerImage.Tags {
+		info, err := convertGarResponseToTagInfo(dockerImage, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, info)
+	}
+	got := convertGarVersionToTagInfo(image, version)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("convertGarVersionToTagInfo, got: %+v but expected: %+v", got, expected)
+	}
+	if len(got) > 0 && !got[0].Created.Equal(built) {
+		t.Errorf("convertGarVersionToTagInfo, got created: %s but expected the build time: %s", got[0].Created, built)
+	}
+
+	// without metadata the build time is unknown
+	version.Metadata = nil
+	if got := convertGarVersionToTagInfo(image, version); len(got) != 2 || !got[0].Created.IsZero() {
+		t.Errorf("convertGarVersionToTagInfo(no metadata), got: %+v but expected a zero created time", got)
+	}
+}
+
+func TestConvertGarResponseToTagInfo(t *testing.T) {
+	hash := "8a1aa5d3eeee07bf5cd75cd1268e132a880ffc829dd02b059b6e68563219522b"
+	created := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	resp := &artifactregistrypb.DockerImage{
+		Uri:       "europe-docker.pkg.dev/acme-org/my-registry/team/app@sha256:" + hash,
+		BuildTime: timestamppb.New(created),
+	}
+	expected := TagInfo{
+		Image:   "europe-docker.pkg.dev/acme-org/my-registry/team/app",
+		Tag:     "1.2.3",
+		Hash:    hash,
+		Created: created,
+	}
+	got, err := convertGarResponseToTagInfo(resp, "1.2.3")
+	if got.Created.Equal(created) {
+		// BuildTime is converted to the local timezone
+		got.Created = created
+	}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("convertGarResponseToTagInfo(%s), got: (%v, %v) but expected: %v", resp.Uri, got, err, expected)
+	}
+
+	resp.Uri = "europe-docker.pkg.dev/acme-org/my-registry/team/app"
+	if _, err := convertGarResponseToTagInfo(resp, "1.2.3"); err == nil {
+		t.Errorf("convertGarResponseToTagInfo(%s), expected an error without a digest", resp.Uri)
+	}
+}
+
+func TestGarPages(t *testing.T) {
+	ctx := withLimits(context.Background(), limits{timeOut: 200 * time.Millisecond})
+	pages := map[string]string{"": "b", "b": "c", "c": ""}
+	var fetched []string
+	err := garPages(ctx, func(ctx context.Context, pageToken string) (string, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("garPages(%q), expected a deadline on each page", pageToken)
+		}
+		// together the pages take longer than the deadline, which applies to each of them
+		time.Sleep(100 * time.Millisecond)
+		if ctx.Err() != nil {
+			t.Errorf("garPages(%q), got: %v but expected no error", pageToken, ctx.Err())
+		}
+		fetched = append(fetched, pageToken)
+		return pages[pageToken], nil
+	})
+	if err != nil || !reflect.DeepEqual(fetched, []string{"", "b", "c"}) {
+		t.Errorf("garPages, got: %v (%v) but expected: %v", fetched, err, []string{"", "b", "c"})
+	}
+}
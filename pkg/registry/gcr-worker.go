@@ -2,92 +2,68 @@ package registry
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/digtux/laminar/pkg/cfg"
-	"github.com/digtux/laminar/pkg/logger"
 	"github.com/google/go-containerregistry/pkg/gcrane"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/google"
 	"github.com/tidwall/buntdb"
 )
 
-func GcrWorker(_ *buntdb.DB, registry cfg.DockerRegistry, _ []string) {
-	logger.Fatalw("Sorry, GCR is not supported yet..")
-	// TODO: GCR support
-	root := registry.Reg
-	ctx := context.Background()
-	err := ls(ctx, root, true, false)
-	logger.Fatalw("Sorry, no GCR support yet",
-		"error", err,
-	)
+func init() {
+	RegisterWorker("gcr", newGcrWorker)
 }
 
-func ls(ctx context.Context, root string, recursive, j bool) error {
-	// working example (Apache2):
-	// https://github.com/google/go-containerregistry/blob/1cfe1fc25f233b40aa5d3b0edd572ed5c3f854c9/cmd/gcrane/cmd/list.go#L57-L94
-	repo, err := name.NewRepository(root)
-	if err != nil {
-		return err
-	}
+// gcrWorker scans Google Container Registry (gcr.io)
+// GCR lists the digest, tags and upload time of every manifest in a single (tags/list) call
+type gcrWorker struct {
+	options []google.Option
+}
 
-	opts := []google.Option{
-		google.WithAuthFromKeychain(gcrane.Keychain),
-		google.WithUserAgent("laminar"),
-		google.WithContext(ctx),
+func newGcrWorker(registry cfg.DockerRegistry, _ *buntdb.DB) (RegistryWorker, error) {
+	keychain := gcrane.Keychain
+	if registry.Auth != nil {
+		var err error
+		if keychain, err = Keychain(registry.Auth); err != nil {
+			return nil, err
+		}
 	}
+	return &gcrWorker{
+		options: []google.Option{
+			google.WithAuthFromKeychain(keychain),
+			google.WithUserAgent("laminar"),
+			google.WithTransport(throttledTransport{base: http.DefaultTransport}),
+		},
+	}, nil
+}
 
-	if recursive {
-		return google.Walk(repo, printImages(j), opts...)
-	}
+func (w *gcrWorker) ListTags(ctx context.Context, image string) ([]TagInfo, error) {
+	return GcrListTags(ctx, image, w.options...)
+}
 
-	tags, err := google.List(repo, opts...)
+// GcrListTags returns a TagInfo for every tag of an image in GCR
+func GcrListTags(ctx context.Context, image string, options ...google.Option) ([]TagInfo, error) {
+	repo, err := name.NewRepository(image)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if !j {
-		if len(tags.Manifests) == 0 && len(tags.Children) == 0 {
-			// If we didn't see any GCR extensions, just list the tags like normal.
-			for _, tag := range tags.Tags {
-				fmt.Printf("%s:%s\n", repo, tag)
-			}
-			return nil
-		}
-
-		// Since we're not recursing, print the subdirectories too.
-		for _, child := range tags.Children {
-			fmt.Printf("%s/%s\n", repo, child)
-		}
+	tags, err := google.List(repo, append(options, google.WithContext(ctx))...)
+	if err != nil {
+		return nil, err
 	}
 
-	return printImages(j)(repo, tags, err)
-}
-
-func printImages(j bool) google.WalkFunc {
-	return func(repo name.Repository, tags *google.Tags, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if j {
-			b, err := json.Marshal(tags)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("%s\n", b)
-			return nil
+	var result []TagInfo
+	for digest, manifest := range tags.Manifests {
+		for _, tag := range manifest.Tags {
+			result = append(result, TagInfo{
+				Image:   image,
+				Hash:    strings.TrimPrefix(digest, "sha256:"),
+				Tag:     tag,
+				Created: manifest.Uploaded,
+			})
 		}
-
-		for digest, manifest := range tags.Manifests {
-			fmt.Printf("%s@%s\n", repo, digest)
-
-			for _, tag := range manifest.Tags {
-				fmt.Printf("%s:%s\n", repo, tag)
-			}
-		}
-
-		return nil
 	}
+	return result, nil
 }
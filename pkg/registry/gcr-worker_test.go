@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/google"
+)
+
+func TestGcrListTags(t *testing.T) {
+	uploaded := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	digestA := fmt.Sprintf("sha256:%064d", 1)
+	digestB := fmt.Sprintf("sha256:%064d", 2)
+
+	// a stand-in for gcr.io, which extends tags/list with every manifest's tags and timestamps
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/myorg/api/tags/list" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(google.Tags{
+			Name: "myorg/api",
+			Tags: []string{"1.0.0", "1.1.0", "stable"},
+			Manifests: map[string]google.ManifestInfo{
+				digestA: {Tags: []string{"1.0.0"}, Uploaded: uploaded},
+				digestB: {Tags: []string{"1.1.0", "stable"}, Uploaded: uploaded.Add(time.Hour)},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := u.Host + "/myorg/api"
+
+	tags, err := GcrListTags(context.Background(), image)
+	if err != nil || len(tags) != 3 {
+		t.Fatalf("GcrListTags(%s), got: (%+v, %v) but expected: 3 tags", image, tags, err)
+	}
+
+	expected := map[string]TagInfo{
+		"1.0.0":  {Image: image, Hash: digestA[7:], Tag: "1.0.0", Created: uploaded},
+		"1.1.0":  {Image: image, Hash: digestB[7:], Tag: "1.1.0", Created: uploaded.Add(time.Hour)},
+		"stable": {Image: image, Hash: digestB[7:], Tag: "stable", Created: uploaded.Add(time.Hour)},
+	}
+	for _, info := range tags {
+		e := expected[info.Tag]
+		if info.Hash != e.Hash || !info.Created.Equal(e.Created) {
+			t.Errorf("GcrListTags(%s), got: %+v but expected: %+v", info.Tag, info, e)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"encoding/json"
+	"expvar"
+	"time"
+
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	minBackoff = 30 * time.Second
+	maxBackoff = 30 * time.Minute
+)
+
+// RegistryHealth is the Health of every scanned registry (by "reg"), see GET /debug/vars (with webDebug)
+var RegistryHealth = expvar.NewMap("laminar_registry_health")
+
+// Health of a registry, a registry which can't be scanned is retried with exponential backoff
+// meanwhile the tags already cached for it are kept
+type Health struct {
+	Failures    int       `json:"failures"` // consecutive
+	LastError   string    `json:"lastError,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+	RetryAfter  time.Time `json:"retryAfter,omitempty"`
+}
+
+// String is for expvar
+func (h Health) String() string {
+	b, _ := json.Marshal(h)
+	return string(b)
+}
+
+// Healthy reports if the last scan of a registry succeeded (registries not scanned yet are healthy)
+func (c *Client) Healthy(reg string) bool {
+	return c.Health(reg).Failures == 0
+}
+
+// Health returns the Health of a registry
+func (c *Client) Health(reg string) Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.health[reg]
+}
+
+// backingOff reports if a registry failed recently and shouldn't be scanned yet
+func (c *Client) backingOff(reg string, now time.Time) bool {
+	return now.Before(c.Health(reg).RetryAfter)
+}
+
+// recordSuccess resets the backoff of a registry
+func (c *Client) recordSuccess(reg string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := Health{LastSuccess: now}
+	c.health[reg] = h
+	RegistryHealth.Set(reg, h)
+}
+
+// recordFailure doubles the backoff of a registry for every consecutive failure (30s, 1m, 2m.. up to 30m)
+func (c *Client) recordFailure(reg string, err error, now time.Time) Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.health[reg]
+	h.Failures++
+	h.LastError = err.Error()
+	backoff := maxBackoff
+	if h.Failures <= 7 {
+		backoff = minBackoff << (h.Failures - 1)
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	h.RetryAfter = now.Add(backoff)
+	c.health[reg] = h
+	RegistryHealth.Set(reg, h)
+	return h
+}
+
+// keepCached renews the TTL of the tags cached for images, so that they outlive a failing registry
+func (c *Client) keepCached(imageList []string) {
+	if len(imageList) == 0 {
+		return
+	}
+	images := map[string]bool{}
+	for _, img := range imageList {
+		images[img] = true
+	}
+	var kept []TagInfo
+	err := c.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("TagInfo:*", func(key, val string) bool {
+			if info := JSONStringToTagInfo(val); images[info.Image] {
+				kept = append(kept, info)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		logger.Warnw("couldn't read cached tags",
+			"error", err,
+		)
+		return
+	}
+	c.storeTags(kept)
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/tidwall/buntdb"
+)
+
+// failingWorker (registry type "failing") fails every call, like a registry with expired credentials
+type failingWorker struct {
+	calls int
+}
+
+var testFailingWorker = &failingWorker{}
+
+func init() {
+	RegisterWorker("failing", func(cfg.DockerRegistry, *buntdb.DB) (RegistryWorker, error) {
+		return testFailingWorker, nil
+	})
+}
+
+func (w *failingWorker) ListTags(context.Context, string) ([]TagInfo, error) {
+	w.calls++
+	return nil, errors.New("401 Unauthorized")
+}
+
+func TestExecWithFailingRegistry(t *testing.T) {
+	testFailingWorker = &failingWorker{}
+	image := "reg.acme.com/api"
+	db := cache.Open(":memory:")
+	// a tag cached by an earlier scan, about to expire
+	err := db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(tagInfoKey(TagInfo{Image: image, Hash: "abcd", Tag: "1.0.0"}),
+			`{"image":"reg.acme.com/api","hash":"abcd","tag":"1.0.0"}`,
+			&buntdb.SetOptions{Expires: true, TTL: time.Second})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(db)
+	registry := cfg.DockerRegistry{Reg: "reg.acme.com", Type: "failing"}
+	c.Exec(registry, []string{image})
+	c.Exec(registry, []string{image})
+
+	// the second scan is skipped during the backoff
+	if testFailingWorker.calls != 1 {
+		t.Errorf("Exec with a failing registry, got: %d calls but expected: 1", testFailingWorker.calls)
+	}
+	health := c.Health(registry.Reg)
+	if c.Healthy(registry.Reg) || health.Failures != 1 || health.LastError != "401 Unauthorized" {
+		t.Errorf("Health(%s), got: %+v", registry.Reg, health)
+	}
+	if backoff := time.Until(health.RetryAfter); backoff < 25*time.Second || backoff > minBackoff {
+		t.Errorf("Health(%s).RetryAfter, got: %s but expected: about %s", registry.Reg, backoff, minBackoff)
+	}
+
+	// the cached tag outlives the failing registry
+	time.Sleep(1100 * time.Millisecond)
+	if cached := c.CachedImagesToTagInfoListSpecificImage(image, "created"); len(cached) != 1 {
+		t.Errorf("Exec with a failing registry, expected the cached tag to be kept, got: %+v", cached)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New(cache.Open(":memory:"))
+	now := time.Now()
+	backoffTests := []time.Duration{
+		30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute,
+		30 * time.Minute, 30 * time.Minute, 30 * time.Minute,
+	}
+	for i, expected := range backoffTests {
+		health := c.recordFailure("reg.acme.com", errors.New("500"), now)
+		if got := health.RetryAfter.Sub(now); got != expected {
+			t.Errorf("recordFailure #%d, got: %s but expected: %s", i+1, got, expected)
+		}
+	}
+	if !c.backingOff("reg.acme.com", now) || c.backingOff("reg.acme.com", now.Add(31*time.Minute)) {
+		t.Errorf("backingOff, expected a backoff of 30m")
+	}
+
+	c.recordSuccess("reg.acme.com", now)
+	if !c.Healthy("reg.acme.com") || c.backingOff("reg.acme.com", now) {
+		t.Errorf("recordSuccess, expected the backoff to be reset, got: %+v", c.Health("reg.acme.com"))
+	}
+}
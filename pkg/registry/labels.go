@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/tidwall/buntdb"
+)
+
+// Labels returns the labels in the image config of an image reference
+// for a manifest list/index the linux/amd64 image is used (see remoteImage)
+func Labels(ctx context.Context, ref string, keychain authn.Keychain) (map[string]string, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	img, err := remoteImage(r,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(throttledTransport{base: remote.DefaultTransport}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	labels := config.Config.Labels
+	if labels == nil {
+		// an image without labels is still a known result
+		labels = map[string]string{}
+	}
+	return labels, nil
+}
+
+// MismatchedLabels returns the required labels (as "key=value") which labels don't have
+func MismatchedLabels(required, labels map[string]string) []string {
+	var mismatched []string
+	for key, value := range required {
+		if labels[key] != value {
+			mismatched = append(mismatched, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched
+}
+
+// CachedLabels returns the labels of a cached tag, fetching them from the registry only once:
+// they're stored with the TagInfo itself (see TagInfoToCache)
+func (c *Client) CachedLabels(ctx context.Context, info TagInfo) (map[string]string, error) {
+	if info.Labels != nil {
+		return info.Labels, nil
+	}
+
+	ref := fmt.Sprintf("%s:%s", info.Image, info.Tag)
+	if info.Hash != "" {
+		ref = fmt.Sprintf("%s@sha256:%s", info.Image, strings.TrimPrefix(info.Hash, "sha256:"))
+	}
+	reg, _ := c.registryFor(info.Image)
+	ctx = c.withRegistryLimits(ctx, reg)
+	labels, err := Labels(ctx, ref, c.keychainFor(info.Image))
+	if err != nil {
+		return nil, err
+	}
+	c.storeLabels(info, labels)
+	return labels, nil
+}
+
+// storeLabels adds labels to a cached TagInfo without extending its TTL
+func (c *Client) storeLabels(info TagInfo, labels map[string]string) {
+	storeKey := tagInfoKey(info)
+	_ = c.db.Update(func(tx *buntdb.Tx) error {
+		existing, err := tx.Get(storeKey)
+		if err != nil {
+			// expired or never cached: nothing to add them to
+			return nil
+		}
+		ttl, err := tx.TTL(storeKey)
+		if err != nil {
+			return err
+		}
+		cached := JSONStringToTagInfo(existing)
+		cached.Labels = labels
+		byteArray, err := json.Marshal(cached)
+		if err != nil {
+			return err
+		}
+		opts := &buntdb.SetOptions{Expires: ttl > 0, TTL: ttl}
+		_, _, err = tx.Set(storeKey, string(byteArray), opts)
+		return err
+	})
+}
@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/digtux/laminar/pkg/cache"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestCachedLabels(t *testing.T) {
+	host := newTestRegistry(t)
+	image := host + "/acme/api"
+
+	img := randomImage(t, v1.Platform{OS: "linux", Architecture: "amd64"})
+	config, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Config.Labels = map[string]string{"com.acme.tests": "passed"}
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushImage(t, image+":1.0.0", img)
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := cache.Open(":memory:")
+	c := New(db)
+	info := TagInfo{Image: image, Hash: digest.Hex, Tag: "1.0.0"}
+	TagInfoToCache(info, db)
+
+	labels, err := c.CachedLabels(context.Background(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, config.Config.Labels) {
+		t.Errorf("CachedLabels(%s), got: %v but expected: %v", info.Tag, labels, config.Config.Labels)
+	}
+
+	// a worker rescanning the registry stores the tag again without labels, they must survive that
+	TagInfoToCache(info, db)
+	cached := c.CachedImagesToTagInfoListSpecificImage(image, "created")
+	if len(cached) != 1 || !reflect.DeepEqual(cached[0].Labels, config.Config.Labels) {
+		t.Errorf("expected labels to be cached, got: %+v", cached)
+	}
+
+	// cached labels are used without contacting the registry
+	cached[0].Image = strings.Replace(cached[0].Image, host, "unreachable.invalid", 1)
+	if _, err := c.CachedLabels(context.Background(), cached[0]); err != nil {
+		t.Errorf("expected cached labels to be used, got: %v", err)
+	}
+}
+
+func TestMismatchedLabels(t *testing.T) {
+	labels := map[string]string{"com.acme.tests": "passed", "org.opencontainers.image.source": "acme/api"}
+	labelTests := []struct {
+		required map[string]string
+		expected []string
+	}{
+		{nil, nil},
+		{map[string]string{"com.acme.tests": "passed"}, nil},
+		{map[string]string{"com.acme.tests": "failed"}, []string{"com.acme.tests=failed"}},
+		{map[string]string{"com.acme.missing": "", "a": "b"}, []string{"a=b"}},
+	}
+	for _, test := range labelTests {
+		if got := MismatchedLabels(test.required, labels); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("MismatchedLabels(%v), got: %v but expected: %v", test.required, got, test.expected)
+		}
+	}
+}
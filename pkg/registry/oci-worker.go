@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/tidwall/buntdb"
+)
+
+func init() {
+	RegisterWorker("oci", newOciWorker)
+}
+
+// ociWorker scans any registry implementing the OCI Distribution spec (registry:2, Harbor, GHCR, Docker Hub, Quay..)
+// tags are listed with /v2/<name>/tags/list and the created time is read from each image config
+type ociWorker struct {
+	db      *buntdb.DB
+	options []remote.Option
+}
+
+func newOciWorker(registry cfg.DockerRegistry, db *buntdb.DB) (RegistryWorker, error) {
+	keychain, err := Keychain(registry.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return &ociWorker{
+		db: db,
+		options: []remote.Option{
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithUserAgent("laminar"),
+			remote.WithTransport(throttledTransport{base: remote.DefaultTransport}),
+		},
+	}, nil
+}
+
+// ListTags returns a TagInfo for every tag of an image
+// the image config (for the created time) is only fetched for digests which aren't cached already
+func (w *ociWorker) ListTags(ctx context.Context, image string) ([]TagInfo, error) {
+	return OciListTags(ctx, image, w.db, w.options...)
+}
+
+// OciListTags lists the tags of an image from any OCI Distribution registry, see ociWorker
+func OciListTags(ctx context.Context, image string, db *buntdb.DB, options ...remote.Option) ([]TagInfo, error) {
+	repo, err := name.NewRepository(image)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, remote.WithContext(ctx))
+	tags, err := remote.List(repo, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result  []TagInfo
+		lastErr error
+	)
+	for _, tag := range tags {
+		info, err := ociTagInfo(db, image, repo.Tag(tag), options...)
+		if err != nil {
+			if ctx.Err() != nil {
+				// the scan was cancelled, the tags listed so far are still worth caching
+				return result, ctx.Err()
+			}
+			// EG: a tag pointing at a deleted or unsupported manifest
+			logger.Warnw("skipping tag which couldn't be read",
+				"image", image,
+				"tag", tag,
+				"error", err,
+			)
+			lastErr = err
+			continue
+		}
+		result = append(result, info)
+	}
+	if len(result) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// ociTagInfo returns the TagInfo of a tag, the image config is only fetched when its digest isn't cached
+func ociTagInfo(db *buntdb.DB, image string, tag name.Tag, options ...remote.Option) (TagInfo, error) {
+	desc, err := remote.Head(tag, options...)
+	if err != nil {
+		return TagInfo{}, err
+	}
+	info := TagInfo{
+		Image: image,
+		Hash:  desc.Digest.Hex,
+		Tag:   tag.TagStr(),
+	}
+	if cached, ok := cachedTagInfo(db, info); ok {
+		return cached, nil
+	}
+	info.Created, err = ociCreated(tag, options...)
+	return info, err
+}
+
+// ociCreated reads the created time from the image config (see remoteImage for an index)
+func ociCreated(ref name.Reference, options ...remote.Option) (time.Time, error) {
+	img, err := remoteImage(ref, options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return config.Created.Time, nil
+}
+
+// remoteImage fetches the image of a reference, for a manifest list/index that's the linux/amd64 image
+// or the first image listed when there's no linux/amd64 one (EG: an arm64 only index)
+func remoteImage(ref name.Reference, options ...remote.Option) (v1.Image, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return desc.Image()
+	}
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var child *v1.Descriptor
+	for i, m := range manifest.Manifests {
+		if !m.MediaType.IsImage() || (m.Platform != nil && m.Platform.OS == "unknown") {
+			// not an image, or an attestation (which buildx lists as "unknown/unknown")
+			continue
+		}
+		if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+			child = &manifest.Manifests[i]
+			break
+		}
+		if child == nil {
+			child = &manifest.Manifests[i]
+		}
+	}
+	if child == nil {
+		return nil, fmt.Errorf("no image in the index of %s", ref)
+	}
+	return idx.Image(child.Digest)
+}
+
+// cachedTagInfo returns the cached TagInfo for the same image, digest and tag
+func cachedTagInfo(db *buntdb.DB, info TagInfo) (TagInfo, bool) {
+	var cached TagInfo
+	found := false
+	_ = db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(tagInfoKey(info))
+		if err != nil {
+			return err
+		}
+		cached, found = JSONStringToTagInfo(val), true
+		return nil
+	})
+	return cached, found
+}
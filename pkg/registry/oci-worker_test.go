@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestOciWorker(t *testing.T) {
+	host := newTestRegistry(t)
+	image := host + "/acme/api"
+
+	created := map[string]time.Time{
+		"1.0.0": time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"1.1.0": time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	digests := map[string]string{}
+	for tag, when := range created {
+		img, err := mutate.CreatedAt(randomImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}), v1.Time{Time: when})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushImage(t, image+":"+tag, img)
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[tag] = digest.Hex
+	}
+	pushIndex(t, image+":multi-arch",
+		v1.Platform{OS: "linux", Architecture: "amd64"},
+		v1.Platform{OS: "linux", Architecture: "arm64"},
+	)
+
+	db := cache.Open(":memory:")
+	New(db).Exec(cfg.DockerRegistry{Reg: host + "/acme"}, []string{image})
+
+	cached := New(db).CachedImagesToTagInfoListSpecificImage(image, "created")
+	if len(cached) != 3 {
+		t.Fatalf("expected 3 cached tags, got: %+v", cached)
+	}
+	// the "created" index sorts newest first, random images are created at the epoch
+	for i, tag := range []string{"1.1.0", "1.0.0", "multi-arch"} {
+		if cached[i].Tag != tag {
+			t.Errorf("OciWorker cached[%d], got: '%s' but expected: '%s'", i, cached[i].Tag, tag)
+		}
+		if when, ok := created[tag]; ok && (!cached[i].Created.Equal(when) || cached[i].Hash != digests[tag]) {
+			t.Errorf("OciWorker(%s), got: (%s, %s) but expected: (%s, %s)",
+				tag, cached[i].Created, cached[i].Hash, when, digests[tag])
+		}
+	}
+}
+
+func TestOciWorkerSkipsBadTags(t *testing.T) {
+	host := newTestRegistry(t)
+	image := host + "/acme/api"
+	pushImage(t, image+":1.0.0", randomImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}))
+	// an index without any image has no created time
+	r, err := name.ParseReference(image + ":empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(r, empty.Index); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := OciListTags(context.Background(), image, cache.Open(":memory:"))
+	if err != nil || len(tags) != 1 || tags[0].Tag != "1.0.0" {
+		t.Errorf("OciListTags(%s), got: (%+v, %v) but expected only 1.0.0", image, tags, err)
+	}
+}
+
+func TestOciCreatedWithoutAmd64(t *testing.T) {
+	host := newTestRegistry(t)
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	img, err := mutate.CreatedAt(randomImage(t, v1.Platform{OS: "linux", Architecture: "arm64"}), v1.Time{Time: when})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := name.ParseReference(host + "/acme/api:arm64-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+	})
+	if err := remote.WriteIndex(r, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ociCreated(r); err != nil || !got.Equal(when) {
+		t.Errorf("ociCreated(%s), got: (%s, %v) but expected: %s", r, got, err, when)
+	}
+}
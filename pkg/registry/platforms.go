@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Platforms returns the platforms (EG: "linux/arm64/v8") an image reference is available for
+// for a manifest list/index that's every platform listed, for a single image it's the platform in its config
+func Platforms(ctx context.Context, ref string, keychain authn.Keychain) ([]string, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(r,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(throttledTransport{base: remote.DefaultTransport}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var platforms []v1.Platform
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil {
+				platforms = append(platforms, *m.Platform)
+			}
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, v1.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+		})
+	}
+
+	var result []string
+	for _, p := range platforms {
+		result = append(result, formatPlatform(p))
+	}
+	return result, nil
+}
+
+func formatPlatform(p v1.Platform) string {
+	parts := []string{p.OS, p.Architecture}
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return strings.Join(parts, "/")
+}
+
+// ValidatePlatform checks a platform looks like "os/arch" or "os/arch/variant"
+func ValidatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("platform %q should look like 'os/arch' or 'os/arch/variant'", platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("platform %q should look like 'os/arch' or 'os/arch/variant'", platform)
+		}
+	}
+	return nil
+}
+
+// MissingPlatforms returns the required platforms which aren't available
+// a required platform without a variant (EG: "linux/arm64") is satisfied by any variant
+func MissingPlatforms(required, available []string) (missing []string) {
+	for _, r := range required {
+		found := false
+		for _, a := range available {
+			if a == r || (strings.Count(r, "/") == 1 && strings.HasPrefix(a, r+"/")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// CachedPlatforms is Platforms for a cached tag, results are kept per digest (which is immutable)
+func (c *Client) CachedPlatforms(ctx context.Context, info TagInfo) ([]string, error) {
+	ref := fmt.Sprintf("%s:%s", info.Image, info.Tag)
+	if info.Hash != "" {
+		ref = fmt.Sprintf("%s@sha256:%s", info.Image, strings.TrimPrefix(info.Hash, "sha256:"))
+	}
+
+	c.mu.Lock()
+	platforms, ok := c.platforms[ref]
+	c.mu.Unlock()
+	if ok {
+		return platforms, nil
+	}
+
+	reg, _ := c.registryFor(info.Image)
+	ctx = c.withRegistryLimits(ctx, reg)
+	platforms, err := Platforms(ctx, ref, c.keychainFor(info.Image))
+	if err != nil {
+		return nil, err
+	}
+	if info.Hash != "" {
+		c.mu.Lock()
+		c.platforms[ref] = platforms
+		c.mu.Unlock()
+	}
+	return platforms, nil
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/digtux/laminar/pkg/logger"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.InitLogger(false); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestRegistry starts an in-memory registry:2 stand-in and returns its host (EG: "127.0.0.1:1234")
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// randomImage returns an image for a platform
+func randomImage(t *testing.T, platform v1.Platform) v1.Image {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.OS, config.Architecture, config.Variant = platform.OS, platform.Architecture, platform.Variant
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func pushImage(t *testing.T, ref string, img v1.Image) {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(r, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pushIndex(t *testing.T, ref string, platforms ...v1.Platform) {
+	t.Helper()
+	var adds []mutate.IndexAddendum
+	for _, p := range platforms {
+		p := p
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        randomImage(t, p),
+			Descriptor: v1.Descriptor{Platform: &p},
+		})
+	}
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(r, mutate.AppendManifests(empty.Index, adds...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	host := newTestRegistry(t)
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	pushIndex(t, host+"/acme/app:multi", amd64, arm64)
+	pushIndex(t, host+"/acme/app:amd64-only", amd64)
+	pushImage(t, host+"/acme/app:single", randomImage(t, arm64))
+
+	platformTests := []struct {
+		tag      string
+		expected []string
+		missing  []string
+	}{
+		{"multi", []string{"linux/amd64", "linux/arm64/v8"}, nil},
+		{"amd64-only", []string{"linux/amd64"}, []string{"linux/arm64"}},
+		{"single", []string{"linux/arm64/v8"}, []string{"linux/amd64"}},
+	}
+	for _, test := range platformTests {
+		platforms, err := Platforms(context.Background(), host+"/acme/app:"+test.tag, authn.DefaultKeychain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(platforms, test.expected) {
+			t.Errorf("Platforms(%s), got: %v but expected: %v", test.tag, platforms, test.expected)
+		}
+		missing := MissingPlatforms([]string{"linux/amd64", "linux/arm64"}, platforms)
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("MissingPlatforms(%s), got: %v but expected: %v", test.tag, missing, test.missing)
+		}
+	}
+
+	if _, err := Platforms(context.Background(), host+"/acme/app:nope", authn.DefaultKeychain); err == nil {
+		t.Error("expected an error for a tag which doesn't exist")
+	}
+	if missing := MissingPlatforms([]string{"linux/arm64/v7"}, []string{"linux/arm64/v8"}); len(missing) != 1 {
+		t.Errorf("expected a variant mismatch to be missing, got: %v", missing)
+	}
+}
@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/logger"
+	"golang.org/x/time/rate"
+)
+
+type limitsKey struct{}
+
+// limits of the registry being called, carried by the context of its API calls
+type limits struct {
+	limiter *rate.Limiter // see requestsPerSecond
+	timeOut time.Duration // the deadline of a single API call
+}
+
+func withLimits(ctx context.Context, l limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, l)
+}
+
+// Throttle blocks until the rate limit of the registry being scanned (see requestsPerSecond) allows another API call
+// workers should call it before each request, it returns early with an error when ctx is done
+func Throttle(ctx context.Context) error {
+	l, ok := ctx.Value(limitsKey{}).(limits)
+	if !ok || l.limiter == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// CallContext returns the context of a single API call, its deadline is the timeOut of the registry being scanned
+// workers should use one per request (or page) rather than for a whole listing, which may take many calls
+func CallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	l, ok := ctx.Value(limitsKey{}).(limits)
+	if !ok || l.timeOut <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, l.timeOut)
+}
+
+// throttledTransport calls Throttle before every HTTP request and gives each its own deadline (see CallContext)
+type throttledTransport struct {
+	base http.RoundTripper
+}
+
+func (t throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := Throttle(req.Context()); err != nil {
+		return nil, err
+	}
+	ctx, cancel := CallContext(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the body is read after RoundTrip returns, the deadline ends once it's closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// ValidateLimits ensures the concurrency, rate limit and timeout of a registry aren't negative (zero is the default)
+func ValidateLimits(registry cfg.DockerRegistry) error {
+	switch {
+	case registry.MaxConcurrency < 0:
+		return fmt.Errorf("maxConcurrency must not be negative, got %d", registry.MaxConcurrency)
+	case registry.RequestsPerSecond < 0:
+		return fmt.Errorf("requestsPerSecond must not be negative, got %v", registry.RequestsPerSecond)
+	case registry.TimeOut < 0:
+		return fmt.Errorf("timeOut must not be negative, got %d", registry.TimeOut)
+	}
+	return nil
+}
+
+// withRegistryLimits applies the limits of a registry to the API calls made with ctx
+func (c *Client) withRegistryLimits(ctx context.Context, registry cfg.DockerRegistry) context.Context {
+	registry = grokRegistrySettings(registry)
+	return withLimits(ctx, limits{
+		limiter: c.limiterFor(registry),
+		timeOut: time.Duration(registry.TimeOut) * time.Second,
+	})
+}
+
+// limiterFor returns the rate limiter of a registry, shared between scans so the limit holds across cycles
+func (c *Client) limiterFor(registry cfg.DockerRegistry) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limit := rate.Inf
+	if registry.RequestsPerSecond > 0 {
+		limit = rate.Limit(registry.RequestsPerSecond)
+	}
+	limiter, ok := c.limiters[registry.Reg]
+	if !ok {
+		limiter = rate.NewLimiter(limit, 1)
+		c.limiters[registry.Reg] = limiter
+	} else if limiter.Limit() != limit {
+		// the config was reloaded
+		limiter.SetLimit(limit)
+	}
+	return limiter
+}
+
+// listTagsConcurrently lists the tags of images with up to maxConcurrency workers, storing them in the cache
+// a failing image doesn't stop the others being scanned
+// an error is only returned when no image could be listed (EG: expired credentials or the registry is down)
+func (c *Client) listTagsConcurrently(ctx context.Context, worker RegistryWorker, registry cfg.DockerRegistry, imageList []string) (int, error) {
+	var (
+		mu        sync.Mutex
+		totalTags int
+		succeeded int
+		failed    []string
+		lastErr   error
+	)
+	workers := registry.MaxConcurrency
+	if workers < 1 {
+		// without a single worker sending the images would block forever
+		workers = 1
+	}
+	images := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(imageList); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range images {
+				total, err := c.listTags(ctx, worker, registry, img)
+				mu.Lock()
+				if err != nil {
+					failed = append(failed, img)
+					lastErr = err
+				} else {
+					succeeded++
+					totalTags += total
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, img := range imageList {
+		images <- img
+	}
+	close(images)
+	wg.Wait()
+
+	if succeeded == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+	// the images which failed keep their cached tags until the next scan
+	c.keepCached(failed)
+	return totalTags, nil
+}
+
+func (c *Client) listTags(ctx context.Context, worker RegistryWorker, registry cfg.DockerRegistry, img string) (int, error) {
+	logger.Debugw("registry worker",
+		"action", "scanning for image tags",
+		"image", img,
+	)
+	tags, err := worker.ListTags(ctx, img)
+	if err != nil {
+		logger.Errorw("registry scan of image failed",
+			"registry", registry.Reg,
+			"image", img,
+			"listedTags", len(tags),
+			"error", err,
+		)
+		// keep the tags listed before it failed
+		c.storeTags(tags)
+		return 0, err
+	}
+	logger.Debugw("indexing image complete",
+		"image", img,
+		"totalTags", len(tags),
+	)
+	return c.storeTags(tags), nil
+}
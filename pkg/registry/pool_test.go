@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digtux/laminar/pkg/cache"
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/tidwall/buntdb"
+)
+
+// poolWorker (registry type "pool") records how many images are listed at once
+// "slow" images block until the deadline, "partial" ones fail after listing a tag
+type poolWorker struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	listed      int
+}
+
+var testPoolWorker *poolWorker
+
+func init() {
+	RegisterWorker("pool", func(cfg.DockerRegistry, *buntdb.DB) (RegistryWorker, error) {
+		return testPoolWorker, nil
+	})
+}
+
+func (w *poolWorker) ListTags(ctx context.Context, image string) ([]TagInfo, error) {
+	if err := Throttle(ctx); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.inFlight++
+	w.listed++
+	if w.inFlight > w.maxInFlight {
+		w.maxInFlight = w.inFlight
+	}
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.inFlight--
+		w.mu.Unlock()
+	}()
+
+	if image == "reg.acme.com/slow" {
+		// an API call which never answers
+		ctx, cancel := CallContext(ctx)
+		defer cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if image == "reg.acme.com/partial" {
+		// the tags listed before failing
+		return []TagInfo{{Image: image, Hash: "abcd", Tag: "1.0.0"}}, errors.New("connection reset")
+	}
+	time.Sleep(20 * time.Millisecond)
+	return []TagInfo{{Image: image, Hash: "abcd", Tag: "1.0.0"}}, nil
+}
+
+func TestExecConcurrency(t *testing.T) {
+	testPoolWorker = &poolWorker{}
+	images := []string{"reg.acme.com/a", "reg.acme.com/b", "reg.acme.com/c", "reg.acme.com/d", "reg.acme.com/e", "reg.acme.com/f"}
+	db := cache.Open(":memory:")
+	New(db).Exec(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "pool", MaxConcurrency: 2}, images)
+
+	if testPoolWorker.maxInFlight != 2 || testPoolWorker.listed != len(images) {
+		t.Errorf("Exec(maxConcurrency: 2), got: %d at once (%d listed) but expected: 2 at once (%d listed)",
+			testPoolWorker.maxInFlight, testPoolWorker.listed, len(images))
+	}
+	for _, image := range images {
+		if cached := New(db).CachedImagesToTagInfoListSpecificImage(image, "created"); len(cached) != 1 {
+			t.Errorf("Exec cached %s, got: %+v", image, cached)
+		}
+	}
+}
+
+func TestExecTimeOut(t *testing.T) {
+	testPoolWorker = &poolWorker{}
+	db := cache.Open(":memory:")
+	start := time.Now()
+	New(db).Exec(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "pool", TimeOut: 1}, []string{"reg.acme.com/slow", "reg.acme.com/a"})
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Exec(timeOut: 1), took: %s", elapsed)
+	}
+	if cached := New(db).CachedImagesToTagInfoListSpecificImage("reg.acme.com/a", "created"); len(cached) != 1 {
+		t.Errorf("Exec(timeOut: 1), a slow image prevented others being cached, got: %+v", cached)
+	}
+}
+
+func TestExecCachesPartialTags(t *testing.T) {
+	testPoolWorker = &poolWorker{}
+	db := cache.Open(":memory:")
+	New(db).Exec(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "pool"}, []string{"reg.acme.com/partial", "reg.acme.com/a"})
+
+	if cached := New(db).CachedImagesToTagInfoListSpecificImage("reg.acme.com/partial", "created"); len(cached) != 1 {
+		t.Errorf("Exec(partial), the tags listed before failing weren't cached, got: %+v", cached)
+	}
+}
+
+func TestExecRequestsPerSecond(t *testing.T) {
+	testPoolWorker = &poolWorker{}
+	images := []string{"reg.acme.com/a", "reg.acme.com/b", "reg.acme.com/c", "reg.acme.com/d", "reg.acme.com/e"}
+	start := time.Now()
+	New(cache.Open(":memory:")).Exec(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "pool", RequestsPerSecond: 20}, images)
+
+	// the first call is immediate, the 4 others wait 50ms each
+	// the limiter may allow a call slightly early, so this allows some slack (but less than one call)
+	const expected, slack = 200 * time.Millisecond, 25 * time.Millisecond
+	if elapsed := time.Since(start); elapsed < expected-slack {
+		t.Errorf("Exec(requestsPerSecond: 20), 5 calls took: %s but expected at least %s (less %s slack)", elapsed, expected, slack)
+	}
+}
+
+func TestExecNegativeConcurrency(t *testing.T) {
+	testPoolWorker = &poolWorker{}
+	images := []string{"reg.acme.com/a", "reg.acme.com/b"}
+	done := make(chan struct{})
+	go func() {
+		New(cache.Open(":memory:")).Exec(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "pool", MaxConcurrency: -1}, images)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exec(maxConcurrency: -1) never returned")
+	}
+	if testPoolWorker.listed != len(images) {
+		t.Errorf("Exec(maxConcurrency: -1), got: %d listed but expected: %d", testPoolWorker.listed, len(images))
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	limitsTests := []struct {
+		registry cfg.DockerRegistry
+		valid    bool
+	}{
+		{cfg.DockerRegistry{}, true},
+		{cfg.DockerRegistry{MaxConcurrency: 8, RequestsPerSecond: 0.5, TimeOut: 10}, true},
+		{cfg.DockerRegistry{MaxConcurrency: -1}, false},
+		{cfg.DockerRegistry{RequestsPerSecond: -1}, false},
+		{cfg.DockerRegistry{TimeOut: -30}, false},
+	}
+	for _, test := range limitsTests {
+		if err := ValidateLimits(test.registry); (err == nil) != test.valid {
+			t.Errorf("ValidateLimits(%+v), got: %v but expected valid: %v", test.registry, err, test.valid)
+		}
+	}
+}
+
+func TestThrottledTransportDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: throttledTransport{base: http.DefaultTransport}}
+	ctx := withLimits(context.Background(), limits{timeOut: 300 * time.Millisecond})
+	get := func(delay string) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?delay="+delay, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	// together these calls take longer than the deadline, which applies to each of them
+	for i := 0; i < 3; i++ {
+		if err := get("150ms"); err != nil {
+			t.Fatalf("throttledTransport(timeOut: 300ms), call %d got: %v but expected no error", i, err)
+		}
+	}
+	if err := get("2s"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("throttledTransport(timeOut: 300ms), got: %v but expected: %v", err, context.DeadlineExceeded)
+	}
+}
@@ -1,14 +1,20 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/imageref"
 	"github.com/digtux/laminar/pkg/logger"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/gcrane"
 	"github.com/tidwall/buntdb"
+	"golang.org/x/time/rate"
 )
 
 // TagInfo is the official data stored in buntDB
@@ -17,42 +23,156 @@ type TagInfo struct {
 	Hash    string    `json:"hash"`
 	Created time.Time `json:"created"`
 	Tag     string    `json:"tag"`
+	// Labels from the image config, nil until they've been fetched (see CachedLabels)
+	Labels map[string]string `json:"labels"`
 }
 
 type Client struct {
-	db *buntdb.DB
+	db         *buntdb.DB
+	registries []cfg.DockerRegistry
+	mu         sync.Mutex
+	platforms  map[string][]string      // "<image>@<digest>" to the platforms it's available for
+	verified   map[string]bool          // "<image>@<digest>:<keys>" known to carry a valid signature
+	limiters   map[string]*rate.Limiter // per registry "reg", see requestsPerSecond
+	health     map[string]Health        // per registry "reg"
 }
 
-func New(db *buntdb.DB) *Client {
+// New returns a Client, the registries are used to find the credentials (auth) of an image
+func New(db *buntdb.DB, registries ...cfg.DockerRegistry) *Client {
 	return &Client{
-		db: db,
+		db:         db,
+		registries: registries,
+		platforms:  map[string][]string{},
+		verified:   map[string]bool{},
+		limiters:   map[string]*rate.Limiter{},
+		health:     map[string]Health{},
 	}
 }
 
-// Exec will check if we support that docker reg and then launch an appropriate worker
+// Exec lists the tags of images with the worker of the registry's type and stores them in the cache
+// a registry which fails is skipped (keeping its cached tags) until its backoff expires, see Health
 func (c *Client) Exec(registry cfg.DockerRegistry, imageList []string) { // grok will add some defaults lest the config doesn't include em
 	registry = grokRegistrySettings(registry)
 	logger.Debugw("DockerRegistry worker launching",
 		"Registry", registry,
+		"type", Type(registry),
 	)
-
-	// Check if the image looks like an ECR image
-	if strings.Contains(registry.Reg, "ecr") {
-		EcrWorker(c.db, registry, imageList)
+	timeStart := time.Now()
+	if c.backingOff(registry.Reg, timeStart) {
+		health := c.Health(registry.Reg)
+		logger.Warnw("skipping unhealthy registry, using cached tags",
+			"registry", registry.Reg,
+			"failures", health.Failures,
+			"lastError", health.LastError,
+			"retryAfter", health.RetryAfter,
+		)
+		c.keepCached(imageList)
 		return
 	}
+	totalTags := 0
+	ctx := c.withRegistryLimits(context.Background(), registry)
 
-	if strings.Contains(registry.Reg, "gcr.io") {
-		GcrWorker(c.db, registry, imageList)
+	worker, err := NewWorker(registry, c.db)
+	if err != nil {
+		c.failed(registry, imageList, fmt.Errorf("couldn't create %s worker: %w", Type(registry), err))
 		return
 	}
+	if closer, ok := worker.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logger.Warnw("couldn't close registry worker",
+					"registry", registry.Reg,
+					"error", err,
+				)
+			}
+		}()
+	}
 
-	if strings.Contains(registry.Reg, "docker.pkg.dev") {
-		GarWorker(c.db, registry, imageList)
-		return
+	if scanner, ok := worker.(RegistryScanner); ok && registry.FullScan {
+		// a full scan takes many API calls, each has the timeOut deadline
+		tags, err := scanner.ScanAll(ctx)
+		if err != nil {
+			c.failed(registry, imageList, err)
+			return
+		}
+		totalTags += c.storeTags(tags)
+	} else {
+		total, err := c.listTagsConcurrently(ctx, worker, registry, imageList)
+		if err != nil {
+			c.failed(registry, imageList, err)
+			return
+		}
+		totalTags += total
+	}
+	c.recordSuccess(registry.Reg, time.Now())
+
+	elapsed := time.Since(timeStart)
+	logger.Infow("registry scan complete",
+		"elapsed", elapsed,
+		"registry", registry.Reg,
+		"type", Type(registry),
+		"totalImages", len(imageList),
+		"totalTags", totalTags,
+	)
+}
+
+// failed records a failing registry and keeps the tags cached for its images
+func (c *Client) failed(registry cfg.DockerRegistry, imageList []string, err error) {
+	health := c.recordFailure(registry.Reg, err, time.Now())
+	logger.Errorw("registry scan failed, using cached tags",
+		"registry", registry.Reg,
+		"type", Type(registry),
+		"failures", health.Failures,
+		"retryAfter", health.RetryAfter,
+		"error", err,
+	)
+	c.keepCached(imageList)
+}
+
+// storeTags caches the tags listed by a worker
+func (c *Client) storeTags(tags []TagInfo) int {
+	for _, info := range tags {
+		TagInfoToCache(info, c.db)
+	}
+	return len(tags)
+}
+
+// registryFor returns the configured registry an image belongs to (the longest matching "reg")
+// images of an unconfigured registry get the default settings
+func (c *Client) registryFor(image string) (cfg.DockerRegistry, bool) {
+	var best *cfg.DockerRegistry
+	for i, reg := range c.registries {
+		if imageref.Within(image, reg.Reg) && (best == nil || len(reg.Reg) > len(best.Reg)) {
+			best = &c.registries[i]
+		}
+	}
+	if best == nil {
+		host, _ := imageref.SplitHost(image)
+		return cfg.DockerRegistry{Reg: host}, false
 	}
+	return *best, true
+}
 
-	logger.Fatal("unable to figure out which kind of registry you have")
+// keychainFor returns the credentials of the registry an image belongs to
+// falling back to the ambient credentials (docker config.json, gcloud.. or AWS for ECR)
+func (c *Client) keychainFor(image string) authn.Keychain {
+	reg, _ := c.registryFor(image)
+	if reg.Auth == nil {
+		if Type(reg) == "ecr" {
+			// gcrane.Keychain has no ECR credential helper
+			return ecrKeychain(nil)
+		}
+		return gcrane.Keychain
+	}
+	keychain, err := Keychain(reg.Auth)
+	if err != nil {
+		logger.Errorw("invalid registry auth, using ambient credentials",
+			"registry", reg.Reg,
+			"error", err,
+		)
+		return gcrane.Keychain
+	}
+	return keychain
 }
 
 // assuming these are unset fields, assume these defaults
@@ -60,6 +180,9 @@ func grokRegistrySettings(in cfg.DockerRegistry) cfg.DockerRegistry {
 	if in.TimeOut == 0 {
 		in.TimeOut = 30
 	}
+	if in.MaxConcurrency == 0 {
+		in.MaxConcurrency = 4
+	}
 	return in
 }
 
@@ -108,23 +231,35 @@ func JSONStringToTagInfo(s string) TagInfo {
 	return data
 }
 
+func tagInfoKey(info TagInfo) string {
+	return fmt.Sprintf("TagInfo:%s:%s:%s", info.Image, info.Hash, info.Tag)
+}
+
+// TagInfoToCache stores a TagInfo, labels already known for the same image/digest/tag are kept
+// so that workers rescanning a registry don't cause the image config to be fetched again
 func TagInfoToCache(info TagInfo, db *buntdb.DB) {
-	storeKey := fmt.Sprintf("TagInfo:%s:%s:%s", info.Image, info.Hash, info.Tag)
+	storeKey := tagInfoKey(info)
 
 	// TTL on tag cache, https://github.com/tidwall/buntdb#data-expiration
 	buntOpts := &buntdb.SetOptions{Expires: true, TTL: time.Second * 300}
 
-	byteArray, err := json.Marshal(info)
-	if err != nil {
-		logger.Fatal(err)
-	}
-
-	insertedValue := string(byteArray)
-	err = db.Update(func(tx *buntdb.Tx) error {
-		_, _, err := tx.Set(storeKey, insertedValue, buntOpts)
+	err := db.Update(func(tx *buntdb.Tx) error {
+		if info.Labels == nil {
+			if existing, err := tx.Get(storeKey); err == nil {
+				info.Labels = JSONStringToTagInfo(existing).Labels
+			}
+		}
+		byteArray, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(storeKey, string(byteArray), buntOpts)
 		return err
 	})
 	if err != nil {
-		logger.Fatal(err)
+		logger.Errorw("couldn't cache tag",
+			"key", storeKey,
+			"error", err,
+		)
 	}
 }
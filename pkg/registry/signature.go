@@ -0,0 +1,212 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/digtux/laminar/pkg/common"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// cosignSignatureAnnotation holds the base64 signature of each layer (payload) in a cosign signature image
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// ErrUnsigned is returned by VerifySignature when no signature artifact exists for a digest
+var ErrUnsigned = errors.New("no cosign signature found")
+
+// ErrInvalidSignature is returned by VerifySignature when signatures exist but none verify
+var ErrInvalidSignature = errors.New("no valid signature")
+
+// SignatureVerifications counts signature checks by result (valid, unsigned, invalid, error)
+// exposed on the web listener under /debug/vars (with webDebug)
+var SignatureVerifications = expvar.NewMap("laminar_signature_verifications")
+
+// simpleSigningPayload is the part of a cosign payload laminar cares about
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// LoadPublicKeys reads PEM encoded public keys (as generated by "cosign generate-key-pair")
+func LoadPublicKeys(paths []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, path := range paths {
+		raw, err := os.ReadFile(common.GetFileAbsPath(path))
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data found", path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// VerifySignature checks the cosign signature artifact ("<image>:sha256-<digest>.sig") of a digest
+// at least one signature must be valid for one of the keys and must be for the digest itself
+func VerifySignature(
+	ctx context.Context,
+	image string,
+	digest string,
+	keys []crypto.PublicKey,
+	keychain authn.Keychain,
+) error {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	sigRef, err := name.ParseReference(fmt.Sprintf("%s:sha256-%s.sig", image, hex))
+	if err != nil {
+		return err
+	}
+	img, err := remote.Image(sigRef,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(throttledTransport{base: remote.DefaultTransport}),
+	)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == 404 {
+			return ErrUnsigned
+		}
+		return err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, layer := range manifest.Layers {
+		sig, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		l, err := img.LayerByDigest(layer.Digest)
+		if err != nil {
+			return err
+		}
+		payload, err := readLayer(l.Compressed)
+		if err != nil {
+			return err
+		}
+		if err := verifyPayload(payload, sig, "sha256:"+hex, keys); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		return nil
+	}
+	if len(failures) == 0 {
+		return ErrUnsigned
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidSignature, strings.Join(failures, "; "))
+}
+
+func readLayer(open func() (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// verifyPayload checks a base64 signature over a simple signing payload, and that the payload is for digest
+func verifyPayload(payload []byte, b64Signature string, digest string, keys []crypto.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(b64Signature)
+	if err != nil {
+		return fmt.Errorf("bad signature encoding: %w", err)
+	}
+	verified := false
+	for _, key := range keys {
+		if verifyWithKey(key, payload, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("signature doesn't match any key")
+	}
+
+	var p simpleSigningPayload
+	if err := json.NewDecoder(bytes.NewReader(payload)).Decode(&p); err != nil {
+		return fmt.Errorf("bad payload: %w", err)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s", p.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+func verifyWithKey(key crypto.PublicKey, payload, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil ||
+			rsa.VerifyPSS(k, crypto.SHA256, hash[:], signature, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// CachedVerifySignature is VerifySignature for a cached tag, only successful verifications are remembered
+// (per digest and set of keys) as a signature may be added to a digest later
+func (c *Client) CachedVerifySignature(ctx context.Context, info TagInfo, keyFiles []string, keys []crypto.PublicKey) error {
+	if info.Hash == "" {
+		SignatureVerifications.Add("error", 1)
+		return errors.New("no digest known for tag")
+	}
+	cacheKey := fmt.Sprintf("%s@%s:%s", info.Image, info.Hash, strings.Join(keyFiles, ","))
+
+	c.mu.Lock()
+	verified := c.verified[cacheKey]
+	c.mu.Unlock()
+	if verified {
+		return nil
+	}
+
+	reg, _ := c.registryFor(info.Image)
+	ctx = c.withRegistryLimits(ctx, reg)
+	err := VerifySignature(ctx, info.Image, info.Hash, keys, c.keychainFor(info.Image))
+	switch {
+	case err == nil:
+		SignatureVerifications.Add("valid", 1)
+		c.mu.Lock()
+		c.verified[cacheKey] = true
+		c.mu.Unlock()
+	case errors.Is(err, ErrUnsigned):
+		SignatureVerifications.Add("unsigned", 1)
+	case errors.Is(err, ErrInvalidSignature):
+		SignatureVerifications.Add("invalid", 1)
+	default:
+		SignatureVerifications.Add("error", 1)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// pushSignature pushes a cosign style signature artifact for digest, signed by key
+func pushSignature(t *testing.T, image string, digest string, signedDigest string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	payload := []byte(fmt.Sprintf(
+		`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`,
+		image, signedDigest,
+	))
+	hash := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json")),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushImage(t, fmt.Sprintf("%s:%s.sig", image, strings.Replace(digest, ":", "-", 1)), img)
+}
+
+func writePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifySignature(t *testing.T) {
+	host := newTestRegistry(t)
+	image := host + "/acme/api"
+	trusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	untrusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	keys, err := LoadPublicKeys([]string{writePublicKey(t, trusted)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digests := map[string]string{}
+	for _, tag := range []string{"signed", "unsigned", "wrong-key", "wrong-digest"} {
+		img := randomImage(t, v1.Platform{OS: "linux", Architecture: "amd64"})
+		pushImage(t, image+":"+tag, img)
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[tag] = digest.String()
+	}
+	pushSignature(t, image, digests["signed"], digests["signed"], trusted)
+	pushSignature(t, image, digests["wrong-key"], digests["wrong-key"], untrusted)
+	pushSignature(t, image, digests["wrong-digest"], digests["signed"], trusted)
+
+	signatureTests := []struct {
+		tag      string
+		expected error
+	}{
+		{"signed", nil},
+		{"unsigned", ErrUnsigned},
+		{"wrong-key", ErrInvalidSignature},
+		{"wrong-digest", ErrInvalidSignature},
+	}
+	for _, test := range signatureTests {
+		err := VerifySignature(context.Background(), image, digests[test.tag], keys, authn.DefaultKeychain)
+		if !errors.Is(err, test.expected) || (err == nil) != (test.expected == nil) {
+			t.Errorf("VerifySignature(%s), got: %v but expected: %v", test.tag, err, test.expected)
+		}
+	}
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.pub")
+	if err := os.WriteFile(bad, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, paths := range [][]string{{bad}, {filepath.Join(t.TempDir(), "missing.pub")}} {
+		if _, err := LoadPublicKeys(paths); err == nil {
+			t.Errorf("LoadPublicKeys(%v), expected an error", paths)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/digtux/laminar/pkg/cfg"
+	"github.com/digtux/laminar/pkg/imageref"
+	"github.com/tidwall/buntdb"
+)
+
+// RegistryWorker lists the tags of the images within a registry
+// API calls should be throttled (see Throttle) and each have the registry's timeOut deadline (see CallContext)
+type RegistryWorker interface {
+	ListTags(ctx context.Context, image string) ([]TagInfo, error)
+}
+
+// RegistryScanner is implemented by workers that can list every image of a registry at once (see fullScan)
+type RegistryScanner interface {
+	ScanAll(ctx context.Context) ([]TagInfo, error)
+}
+
+// WorkerFactory returns the RegistryWorker for a configured registry
+// db is the tag cache, which workers may read to avoid fetching what is already known
+type WorkerFactory func(registry cfg.DockerRegistry, db *buntdb.DB) (RegistryWorker, error)
+
+var (
+	workersMu sync.RWMutex
+	workers   = map[string]WorkerFactory{}
+
+	// EG: "112233445566.dkr.ecr.eu-west-2.amazonaws.com"
+	ecrHostRegex = regexp.MustCompile(`^\d+\.dkr\.ecr(-fips)?\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+)
+
+// RegisterWorker makes a RegistryWorker available as a registry "type", EG: "ecr"
+// it is intended to be called from init() (out-of-tree workers included) and panics if the type is already taken
+func RegisterWorker(kind string, factory WorkerFactory) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	if _, exists := workers[kind]; exists {
+		panic(fmt.Sprintf("registry: RegisterWorker called twice for type %q", kind))
+	}
+	workers[kind] = factory
+}
+
+// WorkerTypes returns the registered registry types (sorted)
+func WorkerTypes() []string {
+	workersMu.RLock()
+	defer workersMu.RUnlock()
+	var result []string
+	for kind := range workers {
+		result = append(result, kind)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Type returns the type of a registry, when it isn't configured it is guessed from the host
+// anything which isn't obviously ECR, GCR or GAR is expected to implement the OCI Distribution spec
+func Type(registry cfg.DockerRegistry) string {
+	if registry.Type != "" {
+		return registry.Type
+	}
+	host, _ := imageref.SplitHost(registry.Reg)
+	host, _, _ = strings.Cut(host, ":")
+	switch {
+	case ecrHostRegex.MatchString(host):
+		return "ecr"
+	case host == "gcr.io" || strings.HasSuffix(host, ".gcr.io"):
+		return "gcr"
+	case strings.HasSuffix(host, "-docker.pkg.dev"):
+		return "gar"
+	default:
+		return "oci"
+	}
+}
+
+// ValidateType ensures the type of a registry has a RegistryWorker
+func ValidateType(registry cfg.DockerRegistry) error {
+	workersMu.RLock()
+	_, ok := workers[Type(registry)]
+	workersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unsupported type %q, expected one of: %s", Type(registry), strings.Join(WorkerTypes(), ", "))
+	}
+	return nil
+}
+
+// NewWorker returns the RegistryWorker for a registry's type
+func NewWorker(registry cfg.DockerRegistry, db *buntdb.DB) (RegistryWorker, error) {
+	if err := ValidateType(registry); err != nil {
+		return nil, err
+	}
+	workersMu.RLock()
+	factory := workers[Type(registry)]
+	workersMu.RUnlock()
+	return factory(registry, db)
+}
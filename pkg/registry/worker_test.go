@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/digtux/laminar/pkg/cfg"
+)
+
+func TestType(t *testing.T) {
+	typeTests := []struct {
+		registry cfg.DockerRegistry
+		expected string
+	}{
+		{cfg.DockerRegistry{Reg: "112233445566.dkr.ecr.eu-west-2.amazonaws.com/acmecorp"}, "ecr"},
+		{cfg.DockerRegistry{Reg: "112233445566.dkr.ecr.eu-west-2.amazonaws.com"}, "ecr"},
+		{cfg.DockerRegistry{Reg: "gcr.io/acmecorp"}, "gcr"},
+		{cfg.DockerRegistry{Reg: "eu.gcr.io/acmecorp"}, "gcr"},
+		{cfg.DockerRegistry{Reg: "europe-docker.pkg.dev/acme-org/my-registry"}, "gar"},
+		{cfg.DockerRegistry{Reg: "registry.secretcorp.io/acme"}, "oci"},
+		{cfg.DockerRegistry{Reg: "localhost:5000"}, "oci"},
+		// hosts containing "ecr" or "gcr.io" aren't mistaken for ECR/GCR
+		{cfg.DockerRegistry{Reg: "ecr.acme.com/app"}, "oci"},
+		{cfg.DockerRegistry{Reg: "gcr.io.acme.com/app"}, "oci"},
+		{cfg.DockerRegistry{Reg: "ecr.acme.com/app", Type: "ecr"}, "ecr"},
+	}
+	for _, test := range typeTests {
+		if got := Type(test.registry); got != test.expected {
+			t.Errorf("Type(%+v), got: '%s' but expected: '%s'", test.registry, got, test.expected)
+		}
+	}
+}
+
+func TestValidateType(t *testing.T) {
+	for _, kind := range []string{"", "ecr", "gar", "gcr", "oci"} {
+		if err := ValidateType(cfg.DockerRegistry{Reg: "reg.acme.com", Type: kind}); err != nil {
+			t.Errorf("ValidateType(%s), got: %v but expected no error", kind, err)
+		}
+	}
+	if err := ValidateType(cfg.DockerRegistry{Reg: "reg.acme.com", Type: "quay"}); err == nil {
+		t.Errorf("ValidateType(quay), expected an error")
+	}
+}
@@ -2,12 +2,17 @@ package web
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/digtux/laminar/pkg/cfg"
 	"github.com/digtux/laminar/pkg/logger"
+	"github.com/digtux/laminar/pkg/pin"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	echopprof "github.com/sevenNt/echo-pprof"
@@ -52,6 +57,13 @@ type DockerBuildJSON struct {
 	DockerRegistryURL string `json:"docker_registry_url"`
 }
 
+// PinRequestJSON is the body of a request to pin an image
+type PinRequestJSON struct {
+	Image    string `json:"image"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // EG: "2h", empty to pin until explicitly unpinned
+}
+
 func stringContains(comment, value string) bool {
 	return bytes.Contains(
 		[]byte(comment),
@@ -63,17 +75,21 @@ type Client struct {
 	PauseChan     chan time.Time
 	BuildChan     chan DockerBuildJSON
 	githubToken   string
+	apiToken      string
 	listenAddress string
 	config        cfg.Config
+	pins          *pin.Store
 }
 
-func New(cfg cfg.Config) *Client {
+func New(cfg cfg.Config, pins *pin.Store) *Client {
 	return &Client{
 		PauseChan:     make(chan time.Time),
 		BuildChan:     make(chan DockerBuildJSON),
 		githubToken:   cfg.Global.GitHubToken,
+		apiToken:      cfg.Global.APIToken,
 		listenAddress: cfg.Global.WebAddress,
 		config:        cfg,
+		pins:          pins,
 	}
 }
 
@@ -82,6 +98,8 @@ func (client *Client) StartWeb() {
 
 	if client.config.Global.WebDebug {
 		echopprof.Wrap(e)
+		// registry health, signature verifications etc
+		e.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
 	}
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogURI:    true,
@@ -108,6 +126,9 @@ func (client *Client) StartWeb() {
 		"/webhooks/build/docker",
 		client.handleDockerBuildWebhook,
 	)
+	e.GET("/pins", client.handleListPins)
+	e.POST("/pins", client.handleAddPin, client.requireAPIToken)
+	e.DELETE("/pins", client.handleRemovePin, client.requireAPIToken)
 	logger.Infow("laminar web listener started",
 		"address", client.listenAddress)
 
@@ -159,6 +180,74 @@ func (client *Client) handleDockerBuildWebhook(ctx echo.Context) (err error) {
 	return err
 }
 
+// requireAPIToken only allows requests with "Authorization: Bearer <apiToken>"
+// without an apiToken configured every request is refused
+func (client *Client) requireAPIToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if client.apiToken == "" {
+			return echo.NewHTTPError(http.StatusForbidden, "set global.apiToken to allow this")
+		}
+		token := strings.TrimPrefix(ctx.Request().Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(client.apiToken)) != 1 {
+			logger.Warnw("refused request without a valid apiToken",
+				"http.URI", ctx.Request().RequestURI,
+				"http.RemoteAddr", ctx.Request().RemoteAddr,
+			)
+			return echo.NewHTTPError(http.StatusUnauthorized, "expected: Authorization: Bearer <apiToken>")
+		}
+		return next(ctx)
+	}
+}
+
+func (client *Client) handleListPins(ctx echo.Context) (err error) {
+	pins, err := client.pins.List()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return ctx.JSON(http.StatusOK, pins)
+}
+
+func (client *Client) handleAddPin(ctx echo.Context) (err error) {
+	u := new(PinRequestJSON)
+	if err = ctx.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "couldn't bind JSON, expected: {\"image\": \"...\", \"reason\": \"...\", \"duration\": \"2h\"}")
+	}
+	var ttl time.Duration
+	if u.Duration != "" {
+		if ttl, err = time.ParseDuration(u.Duration); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	p, err := client.pins.Add(u.Image, u.Reason, ttl)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	logger.Infow("image pinned",
+		"image", p.Image,
+		"reason", p.Reason,
+		"expires", p.Expires,
+	)
+	return ctx.JSON(http.StatusOK, p)
+}
+
+func (client *Client) handleRemovePin(ctx echo.Context) (err error) {
+	image := ctx.QueryParam("image")
+	removed, err := client.pins.Remove(image)
+	if errors.Is(err, pin.ErrInvalidImage) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("image %q is not pinned", image))
+	}
+	logger.Infow("image unpinned",
+		"image", image,
+	)
+	return ctx.String(http.StatusOK, "unpinned")
+}
+
 func isIssueComment(input http.Header) bool {
 	// returns true if any of the event type is "issue_comment"
 	httpHeader := "X-Github-Event"